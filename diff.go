@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// The number of unchanged lines shown around each change in a diff
+const diffContext = 3
+
+// A line of a diff.  The op is ' ' for unchanged lines, '-' for removed lines and '+' for
+// added lines.
+type diffLine struct {
+	op   byte
+	text string
+
+	// The line numbers before and after the change, starting from 0
+	oldLine int
+	newLine int
+}
+
+// Returns the unified diff between the old and new contents of a file
+func unifiedDiff(filename string, oldSrc []byte, newSrc []byte) []byte {
+	lines := diffLines(splitLines(oldSrc), splitLines(newSrc))
+
+	out := new(bytes.Buffer)
+	fmt.Fprintf(out, "--- %s.orig\n+++ %s\n", filename, filename)
+
+	for start := 0; start < len(lines); {
+		if lines[start].op == ' ' {
+			start++
+			continue
+		}
+
+		// Extend the hunk over changes separated by no more than twice the context
+		end := start
+		for i := start; i < len(lines) && i <= end+diffContext*2; i++ {
+			if lines[i].op != ' ' {
+				end = i
+			}
+		}
+
+		from, to := maxInt(start-diffContext, 0), minInt(end+diffContext+1, len(lines))
+		oldCount, newCount := 0, 0
+		for _, line := range lines[from:to] {
+			if line.op != '+' {
+				oldCount++
+			}
+			if line.op != '-' {
+				newCount++
+			}
+		}
+
+		fmt.Fprintf(out, "@@ -%s +%s @@\n", diffRange(lines[from].oldLine, oldCount), diffRange(lines[from].newLine, newCount))
+		for _, line := range lines[from:to] {
+			fmt.Fprintf(out, "%c%s\n", line.op, line.text)
+		}
+		start = to
+	}
+
+	return out.Bytes()
+}
+
+// Returns the lines of the diff between two lists of lines, using the longest common
+// subsequence of lines
+func diffLines(oldLines []string, newLines []string) []diffLine {
+	// common[i][j] is the length of the longest common subsequence of oldLines[i:] and newLines[j:]
+	common := make([][]int, len(oldLines)+1)
+	for i := range common {
+		common[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = maxInt(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for (i < len(oldLines)) || (j < len(newLines)) {
+		switch {
+		case (i < len(oldLines)) && (j < len(newLines)) && (oldLines[i] == newLines[j]):
+			lines = append(lines, diffLine{' ', oldLines[i], i, j})
+			i, j = i+1, j+1
+		case (j == len(newLines)) || ((i < len(oldLines)) && (common[i+1][j] >= common[i][j+1])):
+			lines = append(lines, diffLine{'-', oldLines[i], i, j})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', newLines[j], i, j})
+			j++
+		}
+	}
+	return lines
+}
+
+// Returns the range of a hunk as shown in the hunk header.  Lines are numbered from 1,
+// except for empty ranges, which give the line before them.
+func diffRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func splitLines(src []byte) []string {
+	text := strings.TrimSuffix(string(src), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
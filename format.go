@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+
+	"github.com/lmika/goseq/seqdiagram/parse"
+)
+
+// Runs the "fmt" subcommand, which formats files.  Formatted files are written to stdout
+// unless they are written back to the file or shown as a diff.
+func runFmt(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	flagWrite := flags.Bool("w", false, "Write the result to the file instead of stdout")
+	flagDiff := flags.Bool("d", false, "Show a diff of the changes instead of the result")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		if *flagWrite {
+			return errors.New("fmt: cannot use -w when formatting stdin")
+		}
+		if err := formatFile("-", false, *flagDiff); err != nil {
+			return errors.New("stdin - " + err.Error())
+		}
+		return nil
+	}
+
+	for _, filename := range flags.Args() {
+		if err := formatFile(filename, *flagWrite, *flagDiff); err != nil {
+			return errors.New(filename + " - " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Formats a file.  Files which cannot be parsed are left alone.
+func formatFile(filename string, write bool, diff bool) error {
+	srcFile, err := openSourceFile(filename)
+	if err != nil {
+		return err
+	}
+	src, err := ioutil.ReadAll(srcFile)
+	srcFile.Close()
+	if err != nil {
+		return err
+	}
+
+	nl, comments, err := parse.ParseWithComments(bytes.NewReader(src), filename)
+	if err != nil {
+		return err
+	}
+	res := parse.Format(nl, comments)
+
+	if diff && !bytes.Equal(src, res) {
+		os.Stdout.Write(unifiedDiff(filename, src, res))
+	}
+	if write && !bytes.Equal(src, res) {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filename, res, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if !diff && !write {
+		os.Stdout.Write(res)
+	}
+	return nil
+}
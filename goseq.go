@@ -26,6 +26,24 @@ var flagEmbedded = flag.Bool("e", false, "Generate an embedded SVG file")
 // Setup a watcher to regenerate the file when changed
 var flagWatch = flag.Bool("w", false, "Watch for changes")
 
+// Subcommands, which are selected by the first argument.  Each is given the remaining arguments.
+var subcommands = map[string]func(args []string) error{
+	"fmt":  runFmt,
+	"lint": runLint,
+	"lsp":  runLsp,
+}
+
+// An error returned by a subcommand to exit with a particular status.  The message is
+// written to stderr unless it is blank.
+type exitStatusError struct {
+	status int
+	msg    string
+}
+
+func (e *exitStatusError) Error() string {
+	return e.msg
+}
+
 // Die with error
 func die(msg string) {
 	fmt.Fprintf(os.Stderr, "goseq: %s\n", msg)
@@ -152,6 +170,21 @@ func main() {
 	renderer := SvgRenderer
 	outFile := ""
 
+	if len(os.Args) > 1 {
+		if subcommand, isSubcommand := subcommands[os.Args[1]]; isSubcommand {
+			if err := subcommand(os.Args[2:]); err != nil {
+				if statusErr, isStatusErr := err.(*exitStatusError); isStatusErr {
+					if statusErr.msg != "" {
+						fmt.Fprintf(os.Stderr, "goseq: %s\n", statusErr.msg)
+					}
+					os.Exit(statusErr.status)
+				}
+				die(err.Error())
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 
 	// Select a suitable renderer (based on the suffix of the output file, if there is one)
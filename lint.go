@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lmika/goseq/seqdiagram"
+	"github.com/lmika/goseq/seqdiagram/parse"
+)
+
+// The exit statuses of the "lint" subcommand.  Findings are distinguished from errors so
+// that scripts can tell a diagram with problems from one which could not be checked.
+const (
+	lintStatusFindings = 1
+	lintStatusError    = 2
+)
+
+// A lint finding as it is written in the JSON output.  Lines and columns start from 1.
+type lintJSONFinding struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"endLine"`
+	EndColumn int    `json:"endColumn"`
+	Rule      string `json:"rule"`
+	Message   string `json:"message"`
+}
+
+// Runs the "lint" subcommand, which checks files for likely mistakes.  Exits with a status
+// of 1 if there are any findings, or 2 if any file could not be checked.
+func runLint(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	flagFormat := flags.String("format", "text", "The output format: text or json")
+	flagEnable := flags.String("enable", "", "Comma separated list of the only rules to check")
+	flagDisable := flags.String("disable", "", "Comma separated list of rules not to check")
+	flagList := flags.Bool("list", false, "List the rules and exit")
+	flags.Parse(args)
+
+	if *flagList {
+		for _, rule := range seqdiagram.LintRules {
+			fmt.Printf("%-20s %s\n", rule.Name, rule.Description)
+		}
+		return nil
+	}
+
+	if (*flagFormat != "text") && (*flagFormat != "json") {
+		return &exitStatusError{lintStatusError, "lint: unknown format: " + *flagFormat}
+	}
+	rules, err := selectLintRules(*flagEnable, *flagDisable)
+	if err != nil {
+		return &exitStatusError{lintStatusError, "lint: " + err.Error()}
+	}
+
+	filenames := flags.Args()
+	if len(filenames) == 0 {
+		filenames = []string{"-"}
+	}
+
+	status := 0
+	var findings []*seqdiagram.LintFinding
+	for _, filename := range filenames {
+		fileFindings, err := lintFile(filename, rules)
+		findings = append(findings, fileFindings...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "goseq: %s - %s\n", displayFilename(filename), err.Error())
+			status = lintStatusError
+		}
+	}
+
+	writeLintFindings(os.Stdout, findings, *flagFormat)
+	if (status == 0) && (len(findings) > 0) {
+		status = lintStatusFindings
+	}
+	if status != 0 {
+		return &exitStatusError{status, ""}
+	}
+	return nil
+}
+
+// Returns the rules to check.  If any rules are enabled, only those are checked.
+func selectLintRules(enable string, disable string) ([]*seqdiagram.LintRule, error) {
+	rules := seqdiagram.LintRules
+	if enable != "" {
+		rules = nil
+		for _, name := range strings.Split(enable, ",") {
+			rule := seqdiagram.LookupLintRule(strings.TrimSpace(name))
+			if rule == nil {
+				return nil, fmt.Errorf("unknown rule: %s", name)
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	if disable != "" {
+		disabled := make(map[*seqdiagram.LintRule]bool)
+		for _, name := range strings.Split(disable, ",") {
+			rule := seqdiagram.LookupLintRule(strings.TrimSpace(name))
+			if rule == nil {
+				return nil, fmt.Errorf("unknown rule: %s", name)
+			}
+			disabled[rule] = true
+		}
+
+		enabled := make([]*seqdiagram.LintRule, 0, len(rules))
+		for _, rule := range rules {
+			if !disabled[rule] {
+				enabled = append(enabled, rule)
+			}
+		}
+		rules = enabled
+	}
+
+	return rules, nil
+}
+
+// Checks a file.  Any findings are returned even if the diagram could not be built.
+func lintFile(filename string, rules []*seqdiagram.LintRule) ([]*seqdiagram.LintFinding, error) {
+	srcFile, err := openSourceFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer srcFile.Close()
+
+	nl, err := parse.Parse(srcFile, displayFilename(filename))
+	if err != nil {
+		return nil, err
+	}
+	return seqdiagram.Lint(nl, displayFilename(filename), rules)
+}
+
+// Writes the findings in either the text or JSON format
+func writeLintFindings(w io.Writer, findings []*seqdiagram.LintFinding, format string) {
+	if format == "json" {
+		jsonFindings := make([]lintJSONFinding, len(findings))
+		for i, f := range findings {
+			jsonFindings[i] = lintJSONFinding{f.Span.Filename, f.Span.Start.Line, f.Span.Start.Column,
+				f.Span.End.Line, f.Span.End.Column, f.Rule, f.Message}
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(jsonFindings)
+		return
+	}
+
+	for _, f := range findings {
+		fmt.Fprintf(w, "%s:%d:%d: %s (%s)\n", f.Span.Filename, f.Span.Start.Line, f.Span.Start.Column, f.Message, f.Rule)
+	}
+}
+
+// Returns the name used for a file in messages
+func displayFilename(filename string) string {
+	if (filename == "") || (filename == "-") {
+		return "stdin"
+	}
+	return filename
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lmika/goseq/seqdiagram"
+	"github.com/lmika/goseq/seqdiagram/parse"
+	"github.com/seanpont/assert"
+)
+
+const lintTestDoc = `title: First
+participant Server (colour="red", icon="cylinder")
+participant Unused
+participant Database as "Main DB"
+style nothing (a="b")
+Client->Server: hello
+Client->Sever: typo
+Server->"Main DB": query
+alt: [found]
+    Server->Client: result
+else: [not found]
+end
+opt: never
+end
+title: Second
+`
+
+func TestLint(t *testing.T) {
+	assert := assert.Assert(t)
+
+	findings := lintTestDocument(t, lintTestDoc, seqdiagram.LintRules)
+
+	assert.Equal(findings, []string{
+		"2:21 unread-attribute: participant attribute colour is never read; did you mean color?",
+		"3:1 unused-participant: participant Unused is declared but never sends or receives a message",
+		"5:1 unread-attribute: style nothing is not used by any declaration",
+		"7:1 implicit-typo: participant Sever is created implicitly; did you mean Server?",
+		"11:1 empty-else: else segment has no items",
+		"13:1 empty-block: opt block has no items",
+		"15:1 duplicate-title: title replaces the title on line 1",
+	})
+}
+
+func TestLintUnknownIcon(t *testing.T) {
+	assert := assert.Assert(t)
+
+	findings := lintTestDocument(t, "style participant (icon=\"clowd\")\nA->B: hello\n", seqdiagram.LintRules)
+	assert.Equal(findings, []string{"1:20 unknown-icon: unknown icon clowd; did you mean cloud?"})
+}
+
+func TestLintUnknownIconDoesNotStopDiagramRules(t *testing.T) {
+	assert := assert.Assert(t)
+
+	doc := "participant Server\nparticipant Db (icon=\"cylnder\")\nClient->Server: a\nClient->Sever: b\nServer->Db: c\n"
+	findings := lintTestDocument(t, doc, seqdiagram.LintRules)
+	assert.Equal(findings, []string{
+		"2:17 unknown-icon: unknown icon cylnder; did you mean cylinder?",
+		"4:1 implicit-typo: participant Sever is created implicitly; did you mean Server?",
+	})
+}
+
+func TestLintImplicitTypoReportsLeastUsed(t *testing.T) {
+	assert := assert.Assert(t)
+
+	findings := lintTestDocument(t, "Client->Server: a\nClient->Sever: b\nServer->Client: c\n", seqdiagram.LintRules)
+	assert.Equal(findings, []string{"2:1 implicit-typo: participant Sever is created implicitly; did you mean Server?"})
+
+	// Short names are only similar if they differ by case
+	findings = lintTestDocument(t, "A->B: a\nB->C: b\nC->a: c\n", seqdiagram.LintRules)
+	assert.Equal(findings, []string{"3:1 implicit-typo: participant a is created implicitly; did you mean A?"})
+}
+
+func TestLintRuleSelection(t *testing.T) {
+	assert := assert.Assert(t)
+
+	rules, err := selectLintRules("empty-block, empty-else,unknown-icon", "empty-else")
+	assert.Nil(err)
+	assert.Equal(len(rules), 2)
+	assert.Equal(rules[0].Name, "empty-block")
+	assert.Equal(rules[1].Name, "unknown-icon")
+
+	rules, err = selectLintRules("", "duplicate-title")
+	assert.Nil(err)
+	assert.Equal(len(rules), len(seqdiagram.LintRules)-1)
+
+	_, err = selectLintRules("", "no-such-rule")
+	assert.NotNil(err)
+}
+
+func TestLintJSONOutput(t *testing.T) {
+	assert := assert.Assert(t)
+
+	nl, err := parse.Parse(strings.NewReader(lintTestDoc), "test.seq")
+	assert.Nil(err)
+	findings, err := seqdiagram.Lint(nl, "test.seq", []*seqdiagram.LintRule{seqdiagram.LookupLintRule("duplicate-title")})
+	assert.Nil(err)
+
+	out := new(bytes.Buffer)
+	writeLintFindings(out, findings, "json")
+
+	var jsonFindings []lintJSONFinding
+	assert.Nil(json.Unmarshal(out.Bytes(), &jsonFindings))
+	assert.Equal(jsonFindings, []lintJSONFinding{
+		{"test.seq", 15, 1, 15, 14, "duplicate-title", "title replaces the title on line 1"},
+	})
+}
+
+// Lints a document and returns the findings as strings
+func lintTestDocument(t *testing.T, doc string, rules []*seqdiagram.LintRule) []string {
+	nl, err := parse.Parse(strings.NewReader(doc), "test.seq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := seqdiagram.Lint(nl, "test.seq", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strs := make([]string, len(findings))
+	for i, f := range findings {
+		strs[i] = fmt.Sprintf("%d:%d %s: %s", f.Span.Start.Line, f.Span.Start.Column, f.Rule, f.Message)
+	}
+	return strs
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcRequestFailed  = -32803
+)
+
+// The largest message body the server will read
+const lspMaxMessageLength = 64 << 20
+
+// LSP completion item kinds
+const (
+	lspVariableCompletion = 6
+	lspPropertyCompletion = 10
+	lspKeywordCompletion  = 14
+)
+
+// A message read from the client.  Requests have an ID while notifications do not.
+type rpcMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type rpcErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *rpcError       `json:"error"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// LSP protocol types.  Only the fields used by the server are included.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletionItem struct {
+	Label      string `json:"label"`
+	Kind       int    `json:"kind"`
+	Detail     string `json:"detail,omitempty"`
+	InsertText string `json:"insertText,omitempty"`
+}
+
+type lspMarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspHover struct {
+	Contents lspMarkupContent `json:"contents"`
+	Range    lspRange         `json:"range"`
+}
+
+type lspWorkspaceEdit struct {
+	Changes map[string][]lspTextEdit `json:"changes"`
+}
+
+type lspTextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type lspTextDocumentPositionParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	Position     lspPosition               `json:"position"`
+}
+
+type lspDidOpenParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+}
+
+type lspDidChangeParams struct {
+	TextDocument   lspTextDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type lspDidCloseParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+}
+
+type lspRenameParams struct {
+	lspTextDocumentPositionParams
+	NewName string `json:"newName"`
+}
+
+type lspPublishDiagnosticsParams struct {
+	URI         string          `json:"uri"`
+	Diagnostics []lspDiagnostic `json:"diagnostics"`
+}
+
+// A language server for sequence diagram files.  The server communicates with a single
+// client using JSON-RPC over a pair of streams.
+type lspServer struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// The documents open in the client, keyed by URI
+	docs map[string]*lspDocument
+
+	// Set once the client has asked the server to shut down
+	shutdown bool
+}
+
+func newLspServer(in io.Reader, out io.Writer) *lspServer {
+	return &lspServer{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*lspDocument),
+	}
+}
+
+// Runs the "lsp" subcommand, which serves the language server over stdin and stdout
+func runLsp(args []string) error {
+	return newLspServer(os.Stdin, os.Stdout).serve()
+}
+
+// Handles messages from the client until it asks the server to exit or closes the stream
+func (s *lspServer) serve() error {
+	for {
+		msg, err := s.readMessage()
+		if rpcErr, isRPCErr := err.(*rpcError); isRPCErr {
+			// The body could not be parsed, so the ID of the request is not known
+			if err := s.writeMessage(rpcErrorResponse{"2.0", nil, rpcErr}); err != nil {
+				return err
+			}
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("lsp: exit without shutdown")
+			}
+			return nil
+		}
+
+		// Requests after a shutdown are refused and notifications are dropped
+		var result interface{}
+		if s.shutdown {
+			err = &rpcError{rpcInvalidRequest, "The server has been shut down"}
+		} else {
+			result, err = s.handle(msg)
+		}
+		if msg.ID == nil {
+			continue
+		}
+
+		if err != nil {
+			rpcErr, isRPCErr := err.(*rpcError)
+			if !isRPCErr {
+				rpcErr = &rpcError{rpcRequestFailed, err.Error()}
+			}
+			err = s.writeMessage(rpcErrorResponse{"2.0", msg.ID, rpcErr})
+		} else {
+			err = s.writeMessage(rpcResponse{"2.0", msg.ID, result})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Handles a request or notification, returning the result of a request
+func (s *lspServer) handle(msg *rpcMessage) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1,
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"(", ",", ">"}},
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"renameProvider":     true,
+			},
+			"serverInfo": map[string]string{"name": "goseq"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params lspDidOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params lspDidChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params lspDidCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.writeMessage(rpcNotification{"2.0", "textDocument/publishDiagnostics",
+			lspPublishDiagnosticsParams{params.TextDocument.URI, []lspDiagnostic{}}})
+	case "textDocument/completion":
+		doc, pos, err := s.docPosition(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		return doc.completions(pos), nil
+	case "textDocument/hover":
+		doc, pos, err := s.docPosition(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		if hover := doc.hover(pos); hover != nil {
+			return hover, nil
+		}
+		return nil, nil
+	case "textDocument/definition":
+		doc, pos, err := s.docPosition(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		if location := doc.definition(pos); location != nil {
+			return location, nil
+		}
+		return nil, nil
+	case "textDocument/rename":
+		var params lspRenameParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, hasDoc := s.docs[params.TextDocument.URI]
+		if !hasDoc {
+			return nil, &rpcError{rpcInvalidParams, "Unknown document: " + params.TextDocument.URI}
+		}
+		edits, err := doc.rename(params.Position, params.NewName)
+		if err != nil {
+			return nil, err
+		}
+		return lspWorkspaceEdit{map[string][]lspTextEdit{doc.uri: edits}}, nil
+	default:
+		if msg.ID != nil {
+			return nil, &rpcError{rpcMethodNotFound, "Unsupported method: " + msg.Method}
+		}
+		return nil, nil
+	}
+}
+
+// Analyses the new text of a document and publishes its diagnostics
+func (s *lspServer) update(uri string, text string) error {
+	doc := analyseDocument(uri, uriToFilename(uri), text, s.docs[uri])
+	s.docs[uri] = doc
+
+	return s.writeMessage(rpcNotification{"2.0", "textDocument/publishDiagnostics",
+		lspPublishDiagnosticsParams{uri, doc.diagnostics}})
+}
+
+// Returns the document and position of a request made at a position within a document
+func (s *lspServer) docPosition(params json.RawMessage) (*lspDocument, lspPosition, error) {
+	var posParams lspTextDocumentPositionParams
+	if err := json.Unmarshal(params, &posParams); err != nil {
+		return nil, lspPosition{}, err
+	}
+
+	doc, hasDoc := s.docs[posParams.TextDocument.URI]
+	if !hasDoc {
+		return nil, lspPosition{}, &rpcError{rpcInvalidParams, "Unknown document: " + posParams.TextDocument.URI}
+	}
+	return doc, posParams.Position, nil
+}
+
+// Reads a message, which consists of headers followed by a JSON body.  A body which is not
+// valid JSON returns an rpcError, as the server can continue reading messages after it.
+func (s *lspServer) readMessage() (*rpcMessage, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			if (err == io.EOF) && (line != "") {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		nameValue := strings.SplitN(line, ":", 2)
+		if (len(nameValue) == 2) && strings.EqualFold(strings.TrimSpace(nameValue[0]), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(nameValue[1])); err != nil {
+				return nil, fmt.Errorf("lsp: invalid content length: %s", nameValue[1])
+			} else if length > lspMaxMessageLength {
+				return nil, fmt.Errorf("lsp: content length of %d bytes exceeds the limit of %d bytes", length, lspMaxMessageLength)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("lsp: message without a content length")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+
+	msg := &rpcMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, &rpcError{rpcParseError, "Invalid message: " + err.Error()}
+	}
+	if string(msg.ID) == "null" {
+		msg.ID = nil
+	}
+	return msg, nil
+}
+
+// Writes a message to the client
+func (s *lspServer) writeMessage(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// Converts a file URI into a filename.  Other URIs are returned as they are.
+func uriToFilename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
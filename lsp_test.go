@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/seanpont/assert"
+)
+
+const lspTestURI = "file:///tmp/test.seq"
+
+const lspTestDoc = `participant Client
+participant "Web Shop" as Shop (icon="database")
+Client->Shop: order
+Shop->Clinet: confirm
+"Web Shop"->Client: ship
+`
+
+func TestLspDiagnostics(t *testing.T) {
+	assert := assert.Assert(t)
+
+	responses := runLspSession(t, []interface{}{
+		lspDidOpen("participant A\nA->B: fine\nalt no colon\nend\n"),
+	})
+
+	diagnostics := responses["textDocument/publishDiagnostics"].(map[string]interface{})["diagnostics"].([]interface{})
+	assert.Equal(len(diagnostics), 2)
+
+	first := diagnostics[0].(map[string]interface{})
+	assert.Equal(first["message"], `Unexpected identifier "no": expected message`)
+	assert.Equal(first["range"].(map[string]interface{})["start"], map[string]interface{}{"line": 2.0, "character": 4.0})
+}
+
+func TestLspDefinitionAndHover(t *testing.T) {
+	assert := assert.Assert(t)
+
+	responses := runLspSession(t, []interface{}{
+		lspDidOpen(lspTestDoc),
+		lspRequest(1, "textDocument/definition", lspPositionParams(2, 9)),
+		lspRequest(2, "textDocument/hover", lspPositionParams(4, 3)),
+		lspRequest(3, "textDocument/hover", lspPositionParams(3, 8)),
+		lspRequest(4, "textDocument/definition", lspPositionParams(3, 8)),
+	})
+
+	location := responses["1"].(map[string]interface{})
+	assert.Equal(location["range"].(map[string]interface{})["start"], map[string]interface{}{"line": 1.0, "character": 0.0})
+
+	hover := responses["2"].(map[string]interface{})["contents"].(map[string]interface{})["value"].(string)
+	assert.True(strings.Contains(hover, "**participant** `Shop` as \"Web Shop\""), hover)
+	assert.True(strings.Contains(hover, "- `icon` = `database`"), hover)
+
+	hover = responses["3"].(map[string]interface{})["contents"].(map[string]interface{})["value"].(string)
+	assert.True(strings.Contains(hover, "Not declared"), hover)
+	assert.Equal(responses["4"], nil)
+}
+
+func TestLspRename(t *testing.T) {
+	assert := assert.Assert(t)
+
+	responses := runLspSession(t, []interface{}{
+		lspDidOpen(lspTestDoc),
+		lspRequest(1, "textDocument/rename", lspTestRenameParams(2, 1, "Customer")),
+		lspRequest(2, "textDocument/rename", lspTestRenameParams(2, 9, "Online Shop")),
+	})
+
+	edits := responses["1"].(map[string]interface{})["changes"].(map[string]interface{})[lspTestURI].([]interface{})
+	var lines []float64
+	for _, edit := range edits {
+		edit := edit.(map[string]interface{})
+		assert.Equal(edit["newText"], "Customer")
+		lines = append(lines, edit["range"].(map[string]interface{})["start"].(map[string]interface{})["line"].(float64))
+	}
+	assert.Equal(lines, []float64{0, 2, 4})
+
+	edits = responses["2"].(map[string]interface{})["changes"].(map[string]interface{})[lspTestURI].([]interface{})
+	var newTexts []string
+	for _, edit := range edits {
+		newTexts = append(newTexts, edit.(map[string]interface{})["newText"].(string))
+	}
+	assert.Equal(newTexts, []string{`participant "Online Shop" (icon="database"): Web Shop`, `"Online Shop"`, `"Online Shop"`})
+}
+
+func TestLspCompletion(t *testing.T) {
+	assert := assert.Assert(t)
+
+	responses := runLspSession(t, []interface{}{
+		lspDidOpen(lspTestDoc + "participant X(\nClient->\n"),
+		lspRequest(1, "textDocument/completion", lspPositionParams(5, 14)),
+		lspRequest(2, "textDocument/completion", lspPositionParams(6, 8)),
+	})
+
+	labels := func(items interface{}) []string {
+		var labels []string
+		for _, item := range items.([]interface{}) {
+			labels = append(labels, item.(map[string]interface{})["label"].(string))
+		}
+		return labels
+	}
+
+	assert.Equal(labels(responses["1"]), []string{"color", "footer", "header", "icon", "kind", "lifeline", "stereotype", "textcolor"})
+	assert.Equal(labels(responses["2"]), []string{"Client", "Clinet", "Shop"})
+}
+
+func TestLspInvalidMessage(t *testing.T) {
+	assert := assert.Assert(t)
+
+	responses := runLspSession(t, []interface{}{
+		`{"jsonrpc": "2.0", "id": 1, "method":`,
+		lspDidOpen(lspTestDoc),
+		lspRequest(2, "textDocument/definition", lspPositionParams(2, 2)),
+	})
+
+	assert.Equal(strings.HasPrefix(responses["<nil>"].(string), "map[code:-32700 "), true)
+	assert.NotEqual(responses["2"], nil)
+}
+
+func TestLspRequestAfterShutdown(t *testing.T) {
+	assert := assert.Assert(t)
+
+	responses := runLspSession(t, []interface{}{
+		lspDidOpen(lspTestDoc),
+		lspRequest(1, "shutdown", nil),
+		lspRequest(2, "textDocument/definition", lspPositionParams(2, 9)),
+	})
+
+	assert.Equal(responses["1"], nil)
+	assert.Equal(strings.HasPrefix(responses["2"].(string), "map[code:-32600 "), true)
+}
+
+func TestLspMessageTooLong(t *testing.T) {
+	in := fmt.Sprintf("Content-Length: %d\r\n\r\n", lspMaxMessageLength+1)
+	err := newLspServer(strings.NewReader(in), new(bytes.Buffer)).serve()
+	if (err == nil) || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("expected the message to be refused but got %v", err)
+	}
+}
+
+// Runs a session with the server, returning the results of requests keyed by ID and the
+// last parameters of each notification keyed by method.  Errors are returned as strings.
+// Messages given as strings are sent as they are.
+func runLspSession(t *testing.T, msgs []interface{}) map[string]interface{} {
+	in := new(bytes.Buffer)
+	msgs = append([]interface{}{lspRequest(0, "initialize", map[string]interface{}{})}, msgs...)
+	msgs = append(msgs, lspRequest(99, "shutdown", nil), map[string]interface{}{"jsonrpc": "2.0", "method": "exit"})
+	for _, msg := range msgs {
+		body, isRaw := msg.(string)
+		if !isRaw {
+			jsonBody, _ := json.Marshal(msg)
+			body = string(jsonBody)
+		}
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+
+	out := new(bytes.Buffer)
+	if err := newLspServer(in, out).serve(); err != nil {
+		t.Fatal(err)
+	}
+
+	responses := make(map[string]interface{})
+	outReader := bufio.NewReader(out)
+	for {
+		var length int
+		if _, err := fmt.Fscanf(outReader, "Content-Length: %d\r\n\r\n", &length); err != nil {
+			break
+		}
+		body := make([]byte, length)
+		outReader.Read(body)
+
+		var msg map[string]interface{}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if method, isNotification := msg["method"].(string); isNotification {
+			responses[method] = msg["params"]
+		} else if rpcErr, isErr := msg["error"]; isErr {
+			responses[fmt.Sprint(msg["id"])] = fmt.Sprint(rpcErr)
+		} else {
+			responses[fmt.Sprint(msg["id"])] = msg["result"]
+		}
+	}
+	return responses
+}
+
+func lspRequest(id int, method string, params interface{}) interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+}
+
+func lspDidOpen(text string) interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": lspTestURI, "languageId": "goseq", "version": 1, "text": text},
+	}}
+}
+
+func lspPositionParams(line int, character int) interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": lspTestURI},
+		"position":     map[string]interface{}{"line": line, "character": character},
+	}
+}
+
+func lspTestRenameParams(line int, character int, newName string) interface{} {
+	params := lspPositionParams(line, character).(map[string]interface{})
+	params["newName"] = newName
+	return params
+}
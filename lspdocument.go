@@ -0,0 +1,408 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/lmika/goseq/seqdiagram"
+	"github.com/lmika/goseq/seqdiagram/parse"
+)
+
+// A document open in the language server, along with the results of analysing it
+type lspDocument struct {
+	uri      string
+	filename string
+	lines    []string
+
+	nodes *parse.NodeList
+
+	// The diagram built from the document.  If the document cannot be built, this is the
+	// last diagram which could be, or nil if there is none.
+	diagram *seqdiagram.Diagram
+
+	// The places participant names appear, in the order they appear in the document
+	names []*lspName
+
+	diagnostics []lspDiagnostic
+}
+
+// A place a participant name appears within a document
+type lspName struct {
+	span parse.Span
+
+	// The name of the participant
+	actor string
+
+	// True if the participant is written using its name, rather than its label
+	byName bool
+
+	// The declaration the name is part of.  Nil for references to the participant.
+	decl *parse.ActorNode
+}
+
+// The statement keywords which take the attributes of the named kind of declaration
+var lspAttributeKeywords = map[string]string{
+	"participant": "participant",
+	"create":      "participant",
+	"note":        "note",
+	"rnote":       "note",
+	"hnote":       "note",
+	"box":         "box",
+	"autonumber":  "autonumber",
+	"alt":         "block",
+	"opt":         "block",
+	"loop":        "block",
+	"block":       "block",
+	"break":       "block",
+	"critical":    "block",
+	"neg":         "block",
+	"assert":      "block",
+	"ignore":      "block",
+	"consider":    "block",
+}
+
+// Parses and builds a document.  The previous analysis of the document is used for the
+// diagram if the document can no longer be built.
+func analyseDocument(uri string, filename string, text string, prev *lspDocument) *lspDocument {
+	doc := &lspDocument{
+		uri:         uri,
+		filename:    filename,
+		lines:       strings.Split(text, "\n"),
+		diagnostics: []lspDiagnostic{},
+	}
+
+	nl, err := parse.Parse(strings.NewReader(text), filename)
+	if errs, isErrorList := err.(parse.ErrorList); isErrorList {
+		for _, e := range errs {
+			doc.addDiagnostic(e)
+		}
+	} else if err != nil {
+		doc.addDiagnostic(err)
+	}
+	doc.nodes = nl
+
+	// Names are indexed before building, as including files changes the nodes of blocks
+	doc.indexNames()
+
+	diagram, err := seqdiagram.BuildDiagram(nl, filename)
+	if err == nil {
+		doc.diagram = diagram
+	} else {
+		if prev != nil {
+			doc.diagram = prev.diagram
+		}
+
+		// Errors building a file which could not be parsed are usually caused by the
+		// statements which were skipped
+		if len(doc.diagnostics) == 0 {
+			doc.addDiagnostic(err)
+		}
+	}
+
+	return doc
+}
+
+// Adds a diagnostic for an error.  Errors without a position within the document are placed
+// at the start.
+func (doc *lspDocument) addDiagnostic(err error) {
+	start, msg := parse.Pos{1, 1}, err.Error()
+	if pe, isParseErr := err.(*parse.Error); isParseErr && (pe.Filename == doc.filename) {
+		start, msg = parse.Pos{pe.Line, pe.Column}, pe.Message
+		if start.Column < 1 {
+			start.Column = 1
+		}
+	}
+
+	// Errors are underlined to the end of the line
+	end := parse.Pos{start.Line, len([]rune(strings.TrimRight(doc.line(start.Line), " \t\r"))) + 1}
+	doc.diagnostics = append(doc.diagnostics, lspDiagnostic{doc.lspRange(parse.Span{"", start, end}), 1, "goseq", msg})
+}
+
+// Finds the places participant names appear.  Quoted references are resolved the same way
+// as the tree builder resolves them: by name, and then by label.
+func (doc *lspDocument) indexNames() {
+	declared := make(map[string]bool)
+	labels := make(map[string]string)
+	parse.Walk(doc.nodes, func(node parse.Node) {
+		if an, isActorNode := node.(*parse.ActorNode); isActorNode {
+			declared[an.Ident] = true
+			if _, hasLabel := labels[an.ActorName()]; !hasLabel {
+				labels[an.ActorName()] = an.Ident
+			}
+		}
+	})
+
+	parse.Walk(doc.nodes, func(node parse.Node) {
+		if an, isActorNode := node.(*parse.ActorNode); isActorNode {
+			doc.names = append(doc.names, &lspName{an.IdentSpan, an.Ident, true, an})
+		}
+
+		for _, ref := range parse.ActorRefs(node) {
+			switch r := ref.Ref.(type) {
+			case parse.NormalActorRef:
+				doc.names = append(doc.names, &lspName{ref.Span, string(r), true, nil})
+			case parse.QuotedActorRef:
+				if ident, isLabel := labels[string(r)]; isLabel && !declared[string(r)] {
+					doc.names = append(doc.names, &lspName{ref.Span, ident, false, nil})
+				} else {
+					doc.names = append(doc.names, &lspName{ref.Span, string(r), true, nil})
+				}
+			}
+		}
+	})
+}
+
+// Returns the participant name at a position, or nil if there is none
+func (doc *lspDocument) nameAt(pos lspPosition) *lspName {
+	p := doc.parsePos(pos)
+	for _, name := range doc.names {
+		if (name.span.Start.Line == p.Line) && (name.span.Start.Column <= p.Column) && (p.Column <= name.span.End.Column) {
+			return name
+		}
+	}
+	return nil
+}
+
+// Returns the first declaration of a participant, or nil if it is not declared
+func (doc *lspDocument) declaration(actor string) *parse.ActorNode {
+	for _, name := range doc.names {
+		if (name.decl != nil) && (name.actor == actor) {
+			return name.decl
+		}
+	}
+	return nil
+}
+
+// Returns the location of the declaration of the participant at a position
+func (doc *lspDocument) definition(pos lspPosition) *lspLocation {
+	name := doc.nameAt(pos)
+	if name == nil {
+		return nil
+	}
+
+	decl := doc.declaration(name.actor)
+	if decl == nil {
+		return nil
+	}
+	return &lspLocation{doc.uri, doc.lspRange(decl.Span)}
+}
+
+// Describes the participant at a position, along with its attributes
+func (doc *lspDocument) hover(pos lspPosition) *lspHover {
+	name := doc.nameAt(pos)
+	if name == nil {
+		return nil
+	}
+
+	var actor *seqdiagram.Actor
+	if doc.diagram != nil {
+		for _, a := range doc.diagram.Actors {
+			if a.Name == name.actor {
+				actor = a
+			}
+		}
+	}
+
+	text := new(strings.Builder)
+	fmt.Fprintf(text, "**participant** `%s`", name.actor)
+	if (actor != nil) && (actor.Label != actor.Name) {
+		fmt.Fprintf(text, " as %q", actor.Label)
+	}
+	text.WriteString("\n\n")
+
+	decl := doc.declaration(name.actor)
+	if decl == nil {
+		text.WriteString("Not declared: created by the first message or note referring to it")
+		return &lspHover{lspMarkupContent{"markdown", text.String()}, doc.lspRange(name.span)}
+	}
+	fmt.Fprintf(text, "Declared on line %d\n\n", decl.Span.Start.Line)
+
+	var attrs map[string]string
+	if (actor != nil) && (actor.Attributes != nil) {
+		attrs = actor.Attributes.All()
+	}
+	if len(attrs) == 0 {
+		text.WriteString("No attributes")
+	} else {
+		attrNames := make([]string, 0, len(attrs))
+		for attrName := range attrs {
+			attrNames = append(attrNames, attrName)
+		}
+		sort.Strings(attrNames)
+
+		for _, attrName := range attrNames {
+			fmt.Fprintf(text, "- `%s` = `%s`\n", attrName, attrs[attrName])
+		}
+	}
+
+	return &lspHover{lspMarkupContent{"markdown", text.String()}, doc.lspRange(name.span)}
+}
+
+// Returns the completions at a position.  Within an attribute list, these are the names of
+// the attributes of the statement.  Elsewhere, these are participant names and, at the start
+// of a statement, keywords.
+func (doc *lspDocument) completions(pos lspPosition) []lspCompletionItem {
+	p := doc.parsePos(pos)
+	prefix := string([]rune(doc.line(p.Line))[:p.Column-1])
+	inQuotes := strings.Count(prefix, `"`)%2 == 1
+
+	items := []lspCompletionItem{}
+	if open := strings.LastIndex(prefix, "("); (open >= 0) && !strings.Contains(prefix[open:], ")") {
+		if !inQuotes {
+			for _, attrName := range doc.attributeNames(prefix) {
+				items = append(items, lspCompletionItem{attrName, lspPropertyCompletion, "attribute", ""})
+			}
+		}
+		return items
+	} else if inQuotes || strings.Contains(prefix, ":") {
+		return items
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range doc.names {
+		if !seen[name.actor] {
+			seen[name.actor] = true
+			items = append(items, lspCompletionItem{name.actor, lspVariableCompletion, "participant", quoteName(name.actor)})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+
+	// Keywords are only offered at the start of the statement
+	if strings.TrimSpace(strings.TrimRightFunc(prefix, isIdentRune)) == "" {
+		for _, keyword := range parse.Keywords() {
+			items = append(items, lspCompletionItem{keyword, lspKeywordCompletion, "keyword", ""})
+		}
+	}
+
+	return items
+}
+
+// Returns the names of the attributes of the statement starting a line
+func (doc *lspDocument) attributeNames(line string) []string {
+	words := strings.FieldsFunc(strings.ToLower(line), func(r rune) bool { return !isIdentRune(r) })
+
+	var kinds []string
+	if len(words) >= 2 && words[0] == "style" {
+		if _, isStyleIdent := seqdiagram.AttributeNames[words[1]]; isStyleIdent {
+			kinds = []string{words[1]}
+		}
+	} else if len(words) >= 1 {
+		if kind, hasKind := lspAttributeKeywords[words[0]]; hasKind {
+			kinds = []string{kind}
+		}
+	}
+	if kinds == nil {
+		for kind := range seqdiagram.AttributeNames {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	seen := make(map[string]bool)
+	attrNames := []string{}
+	for _, kind := range kinds {
+		for _, attrName := range seqdiagram.AttributeNames[kind] {
+			if !seen[attrName] {
+				seen[attrName] = true
+				attrNames = append(attrNames, attrName)
+			}
+		}
+	}
+	sort.Strings(attrNames)
+	return attrNames
+}
+
+// Returns the edits renaming the participant at a position.  Only the places the
+// participant is written using its name are changed.
+func (doc *lspDocument) rename(pos lspPosition, newName string) ([]lspTextEdit, error) {
+	name := doc.nameAt(pos)
+	if name == nil {
+		return nil, errors.New("There is no participant at this position")
+	} else if newName == "" {
+		return nil, errors.New("Participant names cannot be blank")
+	}
+
+	for _, other := range doc.names {
+		if (other.actor == newName) && (other.actor != name.actor) {
+			return nil, fmt.Errorf("Participant %s already exists", newName)
+		}
+	}
+
+	edits := []lspTextEdit{}
+	for _, other := range doc.names {
+		if other.actor != name.actor {
+			continue
+		} else if (other.decl != nil) && other.decl.Alias && !parse.IsIdent(newName) {
+			edits = append(edits, lspTextEdit{doc.lspRange(other.decl.Span), renamedAliasDecl(other.decl, newName)})
+		} else if other.byName {
+			edits = append(edits, lspTextEdit{doc.lspRange(other.span), quoteName(newName)})
+		}
+	}
+	return edits, nil
+}
+
+// Returns the declaration of a participant with an alias, renamed to a name which is not an
+// identifier.  Only identifiers can follow "as", so the declaration is rewritten to give the
+// name as a string and the label as a message.
+func renamedAliasDecl(decl *parse.ActorNode, newName string) string {
+	renamed := *decl
+	renamed.Span, renamed.Ident, renamed.Alias = parse.Span{}, newName, false
+	return strings.TrimSpace(string(parse.Format(&parse.NodeList{&renamed, nil}, nil)))
+}
+
+// Returns the line with a line number starting from 1, or a blank string if there is none
+func (doc *lspDocument) line(n int) string {
+	if (n < 1) || (n > len(doc.lines)) {
+		return ""
+	}
+	return doc.lines[n-1]
+}
+
+// Converts a position in the document to an LSP position.  Document columns count characters
+// from 1, while LSP characters count UTF-16 code units from 0.
+func (doc *lspDocument) lspPos(pos parse.Pos) lspPosition {
+	runes := []rune(doc.line(pos.Line))
+	col := pos.Column - 1
+	if col > len(runes) {
+		col = len(runes)
+	} else if col < 0 {
+		col = 0
+	}
+	return lspPosition{pos.Line - 1, len(utf16.Encode(runes[:col]))}
+}
+
+// Converts an LSP position to a position in the document
+func (doc *lspDocument) parsePos(pos lspPosition) parse.Pos {
+	runes := []rune(doc.line(pos.Line + 1))
+	col, units := 0, 0
+	for (col < len(runes)) && (units < pos.Character) {
+		if runes[col] >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+		col++
+	}
+	return parse.Pos{pos.Line + 1, col + 1}
+}
+
+func (doc *lspDocument) lspRange(span parse.Span) lspRange {
+	return lspRange{doc.lspPos(span.Start), doc.lspPos(span.End)}
+}
+
+// Returns a participant name as it is written in a file, quoting names which are not
+// identifiers
+func quoteName(name string) string {
+	if parse.IsIdent(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+func isIdentRune(r rune) bool {
+	return (r == '_') || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -50,4 +50,21 @@ var builtinIcons = map[string]ActorIcon{
 		Horizontal:         true,
 	}},
 	"cloud": &builtinActorIcon{graphbox.PathIcon{graphbox.CloudPathData}},
+
+	// Icons of the UML participant kinds
+	"boundary": &builtinActorIcon{graphbox.BoundaryIcon{Radius: 14, Connector: 8}},
+	"control":  &builtinActorIcon{graphbox.ControlIcon{Radius: 14, ArrowSize: 5}},
+	"entity":   &builtinActorIcon{graphbox.EntityIcon{Radius: 14}},
+	"database": &builtinActorIcon{graphbox.CylinderIcon{
+		EllipseSmallRadius: 5,
+		EllipseLargeRadius: 18,
+		Length:             28,
+	}},
+	"collections": &builtinActorIcon{graphbox.CollectionsIcon{Width: 28, Height: 20, Offset: 5}},
+	"queue": &builtinActorIcon{graphbox.CylinderIcon{
+		EllipseSmallRadius: 5,
+		EllipseLargeRadius: 12,
+		Length:             40,
+		Horizontal:         true,
+	}},
 }
@@ -4,6 +4,8 @@ import (
 	"errors"
 
 	"github.com/lmika/goseq/seqdiagram/graphbox"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
 )
 
 const (
@@ -11,6 +13,37 @@ const (
 	dejaVuSansFont = "DejaVuSans"
 )
 
+// The approximate width of bold DejaVuSans relative to the regular face
+const boldFontWidthFactor = 1.12
+
+// The slant of an italic face, as the horizontal shift per unit of height (about 12 degrees)
+const italicFontSlant = 0.21
+
+// Sets up the variants used to measure bold, italic and code spans.  Code spans are rendered in the
+// generic monospace font so are measured using the Go mono fonts.
+func addFontVariants(font *graphbox.TTFFont) error {
+	monoFont, err := graphbox.NewTTFFontFromByteSlice(gomono.TTF, "gomono")
+	if err != nil {
+		return err
+	}
+	monoBoldFont, err := graphbox.NewTTFFontFromByteSlice(gomonobold.TTF, "gomonobold")
+	if err != nil {
+		return err
+	}
+
+	boldFont := graphbox.WidenedFont{font, boldFontWidthFactor}
+
+	font.SetVariant(graphbox.BoldSpan, boldFont)
+	font.SetVariant(graphbox.CodeSpan, monoFont)
+	font.SetVariant(graphbox.BoldSpan|graphbox.CodeSpan, monoBoldFont)
+
+	font.SetVariant(graphbox.ItalicSpan, graphbox.ObliqueFont{font, italicFontSlant})
+	font.SetVariant(graphbox.BoldSpan|graphbox.ItalicSpan, graphbox.ObliqueFont{boldFont, italicFontSlant})
+	font.SetVariant(graphbox.CodeSpan|graphbox.ItalicSpan, graphbox.ObliqueFont{monoFont, italicFontSlant})
+	font.SetVariant(graphbox.BoldSpan|graphbox.CodeSpan|graphbox.ItalicSpan, graphbox.ObliqueFont{monoBoldFont, italicFontSlant})
+	return nil
+}
+
 // Attempt to load an internal font
 func loadInternalFont(fontName string) (*graphbox.TTFFont, error) {
 	originalFilename := fontName + ".ttf"
@@ -0,0 +1,64 @@
+package graphbox
+
+// ActivationStyle defines the style of activation bars
+type ActivationStyle struct {
+	// The width of the bar
+	Width int
+
+	// The minimum height of the bar
+	MinHeight int
+
+	Color string
+}
+
+// LineEnds returns the offsets, relative to the lifeline, of the left and right edges
+// of the outermost bar when the given number of activations are active.
+func (as ActivationStyle) LineEnds(depth int) LineEnds {
+	if depth <= 0 {
+		return LineEnds{}
+	}
+
+	center := (depth - 1) * as.Width / 2
+	return LineEnds{center - as.Width/2, center + as.Width/2}
+}
+
+// ActivationBar is the narrow rectangle drawn over a lifeline while an actor is
+// active.  Nested activations are drawn offset to the right of the enclosing bar.
+type ActivationBar struct {
+	// The row the bar ends on
+	TR int
+
+	// The nesting depth of the bar, starting from 0
+	Depth int
+
+	// Offset from the top of the starting row.  Used when the bar starts at the
+	// end of a self-referencing arrow.
+	TopOffset int
+
+	style ActivationStyle
+}
+
+// NewActivationBar creates a new activation bar
+func NewActivationBar(toRow int, depth int, style ActivationStyle) *ActivationBar {
+	return &ActivationBar{toRow, depth, 0, style}
+}
+
+func (ab *ActivationBar) Constraint(r, c int, applier ConstraintApplier) {
+	ends := ab.style.LineEnds(ab.Depth + 1)
+	applier.Apply(SizeConstraint{r, c, -ends.Left, ends.Right, 0, 0})
+}
+
+func (ab *ActivationBar) Draw(ctx DrawContext, point Point) {
+	s := SvgStyle{}
+	s.Set("stroke", ab.style.Color)
+	s.Set("fill", "white")
+	s.Set("stroke-width", "2px")
+
+	fy := point.Y + ab.TopOffset
+	if point, isPoint := ctx.PointAt(ab.TR, ctx.C); isPoint {
+		ty := maxInt(point.Y, fy+ab.style.MinHeight)
+		ends := ab.style.LineEnds(ab.Depth + 1)
+
+		ctx.Canvas.Rect(point.X+ends.Left, fy, ends.Right-ends.Left, ty-fy, s.ToStyle())
+	}
+}
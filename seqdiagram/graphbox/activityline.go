@@ -2,6 +2,7 @@ package graphbox
 
 import (
 	"fmt"
+	"math"
 )
 
 // ActivityArrowStem is the type of arrow stem to use for activity arrows
@@ -18,6 +19,21 @@ const (
 	ThickArrowStem = iota
 )
 
+// CircleEnd indicates whether an activity line starts or ends at a filled circle instead
+// of a lifeline.  These are used for found and lost messages.
+type CircleEnd int
+
+const (
+	// NoCircleEnd is used for activity lines between two lifelines
+	NoCircleEnd CircleEnd = iota
+
+	// FoundCircleEnd is used for activity lines which start at a circle to the left of the lifeline
+	FoundCircleEnd
+
+	// LostCircleEnd is used for activity lines which end at a circle to the right of the lifeline
+	LostCircleEnd
+)
+
 // ActivityLineStyle defines the style to use for an activity line
 type ActivityLineStyle struct {
 	Font          Font
@@ -26,9 +42,22 @@ type ActivityLineStyle struct {
 	TextGap       int
 	SelfRefWidth  int
 	SelfRefHeight int
+
+	// The font size and minimum radius of sequence number circles
+	NumberFontSize int
+	NumberRadius   int
+
+	// The distance between the lifeline and the circle of found and lost messages, and
+	// the radius of the circle
+	CircleEndLength int
+	CircleEndRadius int
+
 	//ArrowHead       ActivityArrowHead
 	ArrowHead *ArrowHeadStyle
 	ArrowStem ActivityArrowStem
+
+	// The width at which text is wrapped.  Zero disables wrapping.
+	MaxTextWidth int
 }
 
 // Returns the text style
@@ -41,12 +70,37 @@ func (as ActivityLineStyle) textStyle() string {
 	return s.ToStyle()
 }
 
+// LineEnds are the horizontal offsets, relative to a lifeline, at which an arrow
+// leaving or arriving from the left or right will attach.  These are non-zero when the
+// actor has an activation bar.
+type LineEnds struct {
+	Left, Right int
+}
+
 // ActivityLine is an activity line graphical object
 type ActivityLine struct {
-	TC          int
+	TC int
+
+	// Where the arrow leaves the originating lifeline and arrives at the target lifeline
+	FromEnds LineEnds
+	ToEnds   LineEnds
+
+	// Whether the line starts or ends at a circle.  If so, the line is drawn between
+	// the circle and the lifeline of the column the line is put in.
+	CircleEnd CircleEnd
+
+	// The number of rows below the starting row the arrow arrives on.  Arrows with a
+	// delay are drawn sloping downwards.
+	Delay int
+
+	// If true, an arrow head is also drawn at the start of the line
+	DoubleHeaded bool
+
 	style       ActivityLineStyle
 	textBox     *TextBox
 	textBoxRect Rect
+
+	numberTextBox *TextBox
 }
 
 // NewActivityLine constructs a new ActivityLine
@@ -57,10 +111,18 @@ func NewActivityLine(toCol int, selfRef bool, text string, style ActivityLineSty
 	}
 
 	textBox := NewTextBox(style.Font, style.FontSize, textBoxAlign)
-	textBox.AddText(text)
+	textBox.MaxWidth = style.MaxTextWidth
+	textBox.AddMarkup(text)
 
 	brect := textBox.BoundingRect()
-	return &ActivityLine{toCol, style, textBox, brect}
+	return &ActivityLine{toCol, LineEnds{}, LineEnds{}, NoCircleEnd, 0, false, style, textBox, brect, nil}
+}
+
+// SetSeqNumber sets a sequence number to draw in a circle at the start of the arrow
+func (al *ActivityLine) SetSeqNumber(number string) {
+	al.numberTextBox = NewTextBox(al.style.Font, al.style.NumberFontSize, MiddleTextAlign)
+	al.numberTextBox.Color = "white"
+	al.numberTextBox.AddText(number)
 }
 
 // Constraint returns the constraints of the graphics object
@@ -73,16 +135,30 @@ func (al *ActivityLine) Constraint(r, c int, applier ConstraintApplier) {
 		lc, rc = al.TC, c
 	}
 
-	if al.TC == c {
+	if al.CircleEnd != NoCircleEnd {
+		// The circle is to the left or right of the lifeline
+		w = al.circleEndLength() + al.style.CircleEndRadius + al.style.Margin.X
+
+		applier.Apply(AddSizeConstraint{r, c, 0, 0, h, al.style.Margin.Y})
+		if al.CircleEnd == FoundCircleEnd {
+			applier.Apply(TotalSizeConstraint{r - 1, c - 1, r, c, w - al.ToEnds.Left, 0})
+		} else {
+			applier.Apply(TotalSizeConstraint{r - 1, c, r, c + 1, w + al.FromEnds.Right, 0})
+		}
+	} else if al.TC == c {
 		// An arrow referring to itself
-		w = maxInt(w, al.style.SelfRefWidth) + al.style.TextGap*3
+		w = maxInt(w, al.style.SelfRefWidth) + al.style.TextGap*3 + al.selfRefEnd()
 		h += al.style.TextGap / 2
 
 		applier.Apply(AddSizeConstraint{r, c, 0, 0, h, al.style.Margin.Y + al.style.SelfRefHeight})
 		applier.Apply(TotalSizeConstraint{r - 1, lc, r, lc + 1, w, 0})
 	} else {
 		applier.Apply(AddSizeConstraint{r, c, 0, 0, h, al.style.Margin.Y})
-		applier.Apply(TotalSizeConstraint{r - 1, lc, r, rc, w + al.style.Margin.X*2, 0})
+		endsW := al.FromEnds.Right - al.ToEnds.Left
+		if al.TC < c {
+			endsW = al.ToEnds.Right - al.FromEnds.Left
+		}
+		applier.Apply(TotalSizeConstraint{r - 1, lc, r, rc, w + al.style.Margin.X*2 + endsW, 0})
 	}
 }
 
@@ -90,36 +166,102 @@ func (al *ActivityLine) Constraint(r, c int, applier ConstraintApplier) {
 func (al *ActivityLine) Draw(ctx DrawContext, point Point) {
 	fx, fy := point.X, point.Y
 
-	if ctx.C == al.TC {
+	if al.CircleEnd != NoCircleEnd {
+		al.drawCircleEndLine(ctx, fx, fy)
+	} else if ctx.C == al.TC {
 		// A self reference arrow
 		if point, isPoint := ctx.PointAt(ctx.R, ctx.C+1); isPoint {
 			// Draw an arrow referencing itself
 			ty := point.Y
-			stemX, stemY := fx+al.style.SelfRefWidth, ty+al.style.SelfRefHeight
+			stemX, stemY := fx+al.selfRefEnd()+al.style.SelfRefWidth, ty+al.style.SelfRefHeight
+			sx, ex := fx+al.FromEnds.Right, fx+al.ToEnds.Right
 
-			textX := fx + al.style.TextGap*2
+			textX := fx + al.selfRefEnd() + al.style.TextGap*2
 			textY := ty - al.style.TextGap - al.style.TextGap/2
 			al.renderMessage(ctx, textX, textY, true)
 
 			al.drawArrowStemPath(ctx,
-				[]int{fx, stemX, stemX, fx},
+				[]int{sx, stemX, stemX, ex},
 				[]int{fy, fy, stemY, stemY})
-			al.drawArrow(ctx, fx, stemY, false)
+			al.drawArrow(ctx, ex, stemY, false)
+			if al.DoubleHeaded {
+				al.drawArrow(ctx, sx, fy, false)
+			}
+			al.drawSeqNumber(ctx, sx, fy)
 		}
 	} else {
 
-		if point, isPoint := ctx.PointAt(ctx.R, al.TC); isPoint {
+		if point, isPoint := ctx.PointAt(ctx.R+al.Delay, al.TC); isPoint {
 			tx, ty := point.X, point.Y
+			if al.TC > ctx.C {
+				fx, tx = fx+al.FromEnds.Right, tx+al.ToEnds.Left
+			} else {
+				fx, tx = fx+al.FromEnds.Left, tx+al.ToEnds.Right
+			}
 
 			textX := fx + (tx-fx)/2
-			textY := ty - al.style.TextGap
+			textY := fy - al.style.TextGap
 			al.renderMessage(ctx, textX, textY, false)
 			al.drawArrowStem(ctx, fx, fy, tx, ty)
-			al.drawArrow(ctx, tx, ty, al.TC > ctx.C)
+			al.drawArrowHeads(ctx, fx, fy, tx, ty)
+			al.drawSeqNumber(ctx, fx, fy)
 		}
 	}
 }
 
+// Draws a line which starts or ends at a circle.  The position is that of the lifeline.
+func (al *ActivityLine) drawCircleEndLine(ctx DrawContext, x, y int) {
+	var fx, tx, circleX int
+	if al.CircleEnd == FoundCircleEnd {
+		tx = x + al.ToEnds.Left
+		fx = tx - al.circleEndLength()
+		circleX = fx
+	} else {
+		fx = x + al.FromEnds.Right
+		circleX = fx + al.circleEndLength()
+		tx = circleX - al.style.CircleEndRadius
+	}
+
+	al.renderMessage(ctx, fx+(tx-fx)/2, y-al.style.TextGap, false)
+	al.drawArrowStem(ctx, fx, y, tx, y)
+	ctx.Canvas.Circle(circleX, y, al.style.CircleEndRadius, "stroke:black;fill:black;stroke-width:1px;")
+	if al.CircleEnd == FoundCircleEnd {
+		al.drawArrowHeads(ctx, fx+al.style.CircleEndRadius, y, tx, y)
+	} else {
+		al.drawArrowHeads(ctx, fx, y, tx, y)
+	}
+	al.drawSeqNumber(ctx, fx, y)
+}
+
+// The distance between the lifeline and the circle of found and lost messages.  This is
+// lengthened for messages too wide to fit.
+func (al *ActivityLine) circleEndLength() int {
+	return maxInt(al.style.CircleEndLength, al.textBoxRect.W+al.style.Margin.X*2)
+}
+
+// Returns where a note attached to the line is placed, relative to the lifeline of the column
+// the line ends in.  The note is either to the right or the left of the lifeline, offset by
+// the distance returned.  fromCol is the column the line was put in.
+func (al *ActivityLine) noteAnchor(fromCol int) (bool, int) {
+	switch {
+	case al.CircleEnd == FoundCircleEnd:
+		return true, al.ToEnds.Right
+	case al.CircleEnd == LostCircleEnd:
+		return true, al.FromEnds.Right + al.circleEndLength() + al.style.CircleEndRadius
+	case al.TC == fromCol:
+		return true, al.selfRefEnd() + maxInt(al.style.SelfRefWidth, al.textBoxRect.W+al.style.TextGap*2)
+	case al.TC > fromCol:
+		return true, al.ToEnds.Right
+	default:
+		return false, -al.ToEnds.Left
+	}
+}
+
+// The furthest right either end of a self-referencing arrow will attach to the lifeline
+func (al *ActivityLine) selfRefEnd() int {
+	return maxInt(al.FromEnds.Right, al.ToEnds.Right)
+}
+
 // Draws the arrow stem
 func (al *ActivityLine) drawArrowStem(ctx DrawContext, fx, fy, tx, ty int) {
 	switch al.style.ArrowStem {
@@ -157,6 +299,19 @@ func (al *ActivityLine) renderMessage(ctx DrawContext, tx, ty int, anchorLeft bo
 	al.textBox.Render(ctx.Canvas, tx, ty, anchor)
 }
 
+// Draws the sequence number circle, if there is one, centered on x and y
+func (al *ActivityLine) drawSeqNumber(ctx DrawContext, x, y int) {
+	if al.numberTextBox == nil {
+		return
+	}
+
+	rect := al.numberTextBox.BoundingRect()
+	radius := maxInt(al.style.NumberRadius, maxInt(rect.W, rect.H)/2+2)
+
+	ctx.Canvas.Circle(x, y, radius, "stroke:black;fill:black;stroke-width:1px;")
+	al.numberTextBox.Render(ctx.Canvas, x, y, CenterGravity)
+}
+
 // Draws the arrow head.
 func (al *ActivityLine) drawArrow(ctx DrawContext, x, y int, isRight bool) {
 	headStyle := al.style.ArrowHead
@@ -179,6 +334,49 @@ func (al *ActivityLine) drawArrow(ctx DrawContext, x, y int, isRight bool) {
 	ctx.Canvas.Polyline(xs, ys, StyleFromString(headStyle.BaseStyle).ToStyle())
 }
 
+// Draws the arrow head at the end of a line running from one point to the other.  If the
+// line is double headed, a head is also drawn at the start of the line.
+func (al *ActivityLine) drawArrowHeads(ctx DrawContext, fx, fy, tx, ty int) {
+	if fy == ty {
+		al.drawArrow(ctx, tx, ty, tx > fx)
+		if al.DoubleHeaded {
+			al.drawArrow(ctx, fx, fy, fx > tx)
+		}
+	} else {
+		al.drawSlopedArrow(ctx, fx, fy, tx, ty)
+		if al.DoubleHeaded {
+			al.drawSlopedArrow(ctx, tx, ty, fx, fy)
+		}
+	}
+}
+
+// Draws the arrow head at the end of a sloping arrow.  The head is rotated so that it
+// follows the slope of the stem.
+func (al *ActivityLine) drawSlopedArrow(ctx DrawContext, fx, fy, tx, ty int) {
+	headStyle := al.style.ArrowHead
+	isRight := tx > fx
+
+	// The head is mirrored for left pointing arrows before being rotated
+	angle := math.Atan2(float64(ty-fy), float64(tx-fx))
+	if !isRight {
+		angle -= math.Pi
+	}
+	cos, sin := math.Cos(angle), math.Sin(angle)
+
+	var xs, ys = make([]int, len(headStyle.Xs)), make([]int, len(headStyle.Ys))
+	for i := range headStyle.Xs {
+		ox, oy := float64(headStyle.Xs[i]), float64(headStyle.Ys[i])
+		if !isRight {
+			ox = -ox
+		}
+
+		xs[i] = tx + int(math.Round(ox*cos-oy*sin))
+		ys[i] = ty + int(math.Round(ox*sin+oy*cos))
+	}
+
+	ctx.Canvas.Polyline(xs, ys, StyleFromString(headStyle.BaseStyle).ToStyle())
+}
+
 // ArrowHeadStyle defines style information for the arrow heads
 type ArrowHeadStyle struct {
 	// Points from the origin
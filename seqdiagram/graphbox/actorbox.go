@@ -24,6 +24,9 @@ type ActorBoxStyle struct {
 	Margin    Point
 	Color     string
 	TextColor string
+
+	// The stereotype shown above the label.  Blank for none.
+	Stereotype string
 }
 
 // ActorBox represents an a actor
@@ -40,6 +43,9 @@ func NewActorBox(text string, style ActorBoxStyle, pos ActorBoxPos) *ActorBox {
 
 	textBox := NewTextBox(style.Font, style.FontSize, textAlign)
 	textBox.Color = style.TextColor
+	if style.Stereotype != "" {
+		textBox.AddText("«" + style.Stereotype + "»")
+	}
 	textBox.AddText(text)
 
 	trect := textBox.BoundingRect()
@@ -76,6 +82,16 @@ func (tr *ActorBox) Constraint(r, c int, applier ConstraintApplier) {
 	}
 }
 
+// LineEnds returns where arrows attach to the sides of the actor box
+func (tr *ActorBox) LineEnds() LineEnds {
+	return LineEnds{-tr.frameRect.W / 2, tr.frameRect.W / 2}
+}
+
+// BoundingRect returns the rectangle occupied by the actor box relative to its position
+func (tr *ActorBox) BoundingRect() Rect {
+	return tr.frameRect.PositionAt(0, 0, CenterGravity)
+}
+
 func (r *ActorBox) Draw(ctx DrawContext, point Point) {
 	s := SvgStyle{}
 	s.Set("stroke", r.style.Color)
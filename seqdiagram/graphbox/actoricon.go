@@ -9,6 +9,9 @@ type ActorIconBoxStyle struct {
 	IconGap   int
 	Color     string
 	TextColor string
+
+	// The stereotype shown above the label.  Blank for none.
+	Stereotype string
 }
 
 // ActorIconBox represents an actor icon
@@ -24,6 +27,9 @@ type ActorIconBox struct {
 func NewActorIconBox(text string, icon Icon, style ActorIconBoxStyle, pos ActorBoxPos) *ActorIconBox {
 	textBox := NewTextBox(style.Font, style.FontSize, MiddleTextAlign)
 	textBox.Color = style.TextColor
+	if style.Stereotype != "" {
+		textBox.AddText("«" + style.Stereotype + "»")
+	}
 	textBox.AddText(text)
 
 	return &ActorIconBox{textBox, icon, style, pos}
@@ -52,9 +58,27 @@ func (tr *ActorIconBox) Constraint(r, c int, applier ConstraintApplier) {
 			applier.Apply(AddSizeConstraint{r, c, w / 2, w / 2, 0, 0})
 		}
 		applier.Apply(SizeConstraint{r, c, 0, 0, topH, bottomH})
+	} else if posVert == BottomActorBox {
+		// Only the icons of the UML participant kinds are used as footers
+		applier.Apply(SizeConstraint{r, c, 0, 0, topH + tr.style.Margin.Y, bottomH})
 	}
 }
 
+// LineEnds returns where arrows attach to the sides of the icon
+func (tr *ActorIconBox) LineEnds() LineEnds {
+	iconW, _ := tr.Icon.Size()
+	return LineEnds{-iconW / 2, iconW / 2}
+}
+
+// BoundingRect returns the rectangle occupied by the icon and its caption relative to its position
+func (tr *ActorIconBox) BoundingRect() Rect {
+	iconW, iconH := tr.Icon.Size()
+	brect := tr.textBox.BoundingRect()
+	w := maxInt(iconW, brect.W) + tr.style.Padding.X
+
+	return Rect{-w / 2, -iconH / 2, w, iconH + tr.style.IconGap + brect.H + tr.style.Padding.Y}
+}
+
 func (tr *ActorIconBox) Draw(ctx DrawContext, point Point) {
 	centerX, centerY := point.X, point.Y
 
@@ -13,6 +13,9 @@ type BlockStyle struct {
 	PrefixExtraWidth int
 	GapWidth         int
 	MidMargin        int
+
+	// The width at which text is wrapped.  Zero disables wrapping.
+	MaxTextWidth int
 }
 
 // A block
@@ -38,7 +41,8 @@ func NewBlock(toRow int, toCol int, marginMup int, isLast bool, prefix string, s
 	prefixTextBoxRect := prefixTextBox.BoundingRect()
 
 	messageTextBox := NewTextBox(style.Font, style.FontSize, MiddleTextAlign)
-	messageTextBox.AddText(text)
+	messageTextBox.MaxWidth = style.MaxTextWidth
+	messageTextBox.AddMarkup(text)
 	messageTextBoxRect := messageTextBox.BoundingRect()
 
 	return &Block{toRow, toCol, marginMup, isLast, showPrefix, text != "", style, prefixTextBox, prefixTextBoxRect, messageTextBox, messageTextBoxRect}
@@ -111,14 +115,14 @@ func (block *Block) drawText(ctx DrawContext, fx, fy int) {
 	}
 
 	if block.ShowPrefix {
-		block.drawPrefixFrame(ctx, ptr.X, ptr.Y, ptr.X+ptr.W, ptr.Y+ptr.H)
+		drawPrefixFrame(ctx, ptr.X, ptr.Y, ptr.X+ptr.W, ptr.Y+ptr.H, block.Style.FontSize/2)
 		block.prefixTextBox.Render(ctx.Canvas, ptr.X+block.Style.TextPadding.X, ptr.Y+block.Style.TextPadding.Y, NorthWestGravity)
 	}
 }
 
-func (block *Block) drawPrefixFrame(ctx DrawContext, fx, fy, tx, ty int) {
-	fold := block.Style.FontSize / 2
-
+// Draws the pentagon shaped frame around a block prefix.  The bottom right corner is
+// folded in by the fold amount.
+func drawPrefixFrame(ctx DrawContext, fx, fy, tx, ty int, fold int) {
 	xs := []int{fx, fx, tx - fold, tx, tx}
 	ys := []int{fy, ty, ty, ty - fold, fy}
 
@@ -0,0 +1,78 @@
+package graphbox
+
+// DimensionLineStyle defines the style of dimension lines
+type DimensionLineStyle struct {
+	Font     Font
+	FontSize int
+
+	// The gap between the column and the dimension line
+	Gap int
+
+	// The gap between the dimension line and the label
+	TextGap int
+
+	// The length of the arrow heads
+	ArrowSize int
+}
+
+// DimensionLine is a vertical line with arrow heads at both ends, drawn to the left of a
+// column between the row it is put in and another row.  It is used to mark the time
+// between two messages.
+type DimensionLine struct {
+	TR int
+
+	// The distance between the line and the column, in addition to the gap in the style.
+	// Used to keep dimension lines over overlapping rows apart.
+	Offset int
+
+	style    DimensionLineStyle
+	textBox  *TextBox
+	textRect Rect
+}
+
+// NewDimensionLine creates a new dimension line
+func NewDimensionLine(toRow int, text string, style DimensionLineStyle) *DimensionLine {
+	textBox := NewTextBox(style.Font, style.FontSize, RightTextAlign)
+	textBox.AddMarkup(text)
+
+	return &DimensionLine{toRow, 0, style, textBox, textBox.BoundingRect()}
+}
+
+// Width returns the space taken up by the line and its label to the left of the column,
+// excluding the offset.
+func (dl *DimensionLine) Width() int {
+	return dl.style.Gap + dl.style.TextGap + dl.textRect.W
+}
+
+func (dl *DimensionLine) Constraint(r, c int, applier ConstraintApplier) {
+	applier.Apply(SizeConstraint{r, c, dl.Offset + dl.Width(), 0, 0, 0})
+}
+
+func (dl *DimensionLine) Draw(ctx DrawContext, point Point) {
+	toPoint, isPoint := ctx.PointAt(dl.TR, ctx.C)
+	if !isPoint {
+		return
+	}
+
+	lineX := point.X - dl.Offset - dl.style.Gap
+	fy, ty := point.Y, toPoint.Y
+	if ty < fy {
+		fy, ty = ty, fy
+	}
+
+	// Extension lines from the column to the dimension line at each row
+	extStyle := "stroke:black;stroke-width:1px;stroke-dasharray:2,2;"
+	ctx.Canvas.Line(lineX-dl.style.ArrowSize, fy, point.X, fy, extStyle)
+	ctx.Canvas.Line(lineX-dl.style.ArrowSize, ty, point.X, ty, extStyle)
+
+	lineStyle := "stroke:black;stroke-width:1px;fill:none;"
+	ctx.Canvas.Line(lineX, fy, lineX, ty, lineStyle)
+
+	as := dl.style.ArrowSize
+	if ty-fy >= as*2 {
+		ctx.Canvas.Polyline([]int{lineX - as/2, lineX, lineX + as/2}, []int{fy + as, fy, fy + as}, lineStyle)
+		ctx.Canvas.Polyline([]int{lineX - as/2, lineX, lineX + as/2}, []int{ty - as, ty, ty - as}, lineStyle)
+	}
+
+	dl.textBox.Render(ctx.Canvas, lineX-dl.style.TextGap, fy+(ty-fy)/2, EastGravity)
+}
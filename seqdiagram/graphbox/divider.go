@@ -29,6 +29,9 @@ type DividerStyle struct {
 	TextPadding Point
 	Overlap     int
 	Shape       DividerShape
+
+	// The width at which text is wrapped.  Zero disables wrapping.
+	MaxTextWidth int
 }
 
 // Divider is a divider graphics object.  This spans the entire diagram.
@@ -48,7 +51,8 @@ type Divider struct {
 // NewDivider creates a new divider
 func NewDivider(toCol int, text string, style DividerStyle) *Divider {
 	textBox := NewTextBox(style.Font, style.FontSize, MiddleTextAlign)
-	textBox.AddText(text)
+	textBox.MaxWidth = style.MaxTextWidth
+	textBox.AddMarkup(text)
 	textBoxRect := textBox.BoundingRect()
 	marginRect := textBoxRect.BlowOut(style.Padding)
 
@@ -26,6 +26,62 @@ type Font interface {
 	Measure(txt string, size float64) (int, int)
 }
 
+// A font with separate variants for measuring styled spans of text
+type StyledFont interface {
+	Font
+
+	// Returns the font used to measure spans with the given style
+	Variant(style SpanStyle) Font
+}
+
+// The span styles which affect the font used to measure a span
+const fontAffectingSpanStyles = BoldSpan | ItalicSpan | CodeSpan
+
+// Returns the font to use for a span with the given style.  Returns the font itself if the
+// font does not have a variant for the style.
+func fontVariant(font Font, style SpanStyle) Font {
+	if sf, isStyled := font.(StyledFont); isStyled && style&fontAffectingSpanStyles != 0 {
+		return sf.Variant(style)
+	}
+	return font
+}
+
+// A font whose measurements are those of another font widened by a factor.  Used to
+// approximate the measurements of a bold face that is not available.
+type WidenedFont struct {
+	Font   Font
+	Factor float64
+}
+
+func (wf WidenedFont) SvgName() string {
+	return wf.Font.SvgName()
+}
+
+func (wf WidenedFont) Measure(txt string, size float64) (int, int) {
+	w, h := wf.Font.Measure(txt, size)
+	return int(math.Ceil(float64(w) * wf.Factor)), h
+}
+
+// A font whose measurements are those of another font slanted by a factor.  The slant
+// leaves the top of the last glyph overhanging the advance width, so the overhang is added
+// to the measured width.  Used to approximate the measurements of an italic face.
+type ObliqueFont struct {
+	Font  Font
+	Slant float64
+}
+
+func (of ObliqueFont) SvgName() string {
+	return of.Font.SvgName()
+}
+
+func (of ObliqueFont) Measure(txt string, size float64) (int, int) {
+	w, h := of.Font.Measure(txt, size)
+	if txt == "" {
+		return w, h
+	}
+	return w + int(math.Ceil(size*of.Slant)), h
+}
+
 // Given a font, font size, points and gravity, returns a rectangle which will contain
 // the text centered.  The point and gravity describes the location of the rect.
 // The second point is where the text is to start given that it is to be rendered to
@@ -43,6 +99,9 @@ func MeasureFontRect(font Font, size int, text string, x, y int, gravity Gravity
 type TTFFont struct {
 	font     *truetype.Font
 	fontName string
+
+	// Fonts used to measure styled spans
+	variants map[SpanStyle]Font
 }
 
 // Returns a new TTFFont struct
@@ -76,7 +135,29 @@ func NewTTFFontFromByteSlice(bytes []byte, fontName string) (*TTFFont, error) {
 		return nil, err
 	}
 
-	return &TTFFont{ttfFont, fontName}, nil
+	return &TTFFont{ttfFont, fontName, nil}, nil
+}
+
+// Sets the font used to measure spans with the given style
+func (ttf *TTFFont) SetVariant(style SpanStyle, font Font) {
+	if ttf.variants == nil {
+		ttf.variants = make(map[SpanStyle]Font)
+	}
+	ttf.variants[style&fontAffectingSpanStyles] = font
+}
+
+// Returns the font used to measure spans with the given style.  Falls back to the variant
+// without italics, then the code variant for code spans and the regular font for anything else.
+func (ttf *TTFFont) Variant(style SpanStyle) Font {
+	style &= fontAffectingSpanStyles
+	if font, hasVariant := ttf.variants[style]; hasVariant {
+		return font
+	} else if font, hasVariant := ttf.variants[style&^ItalicSpan]; hasVariant {
+		return font
+	} else if font, hasVariant := ttf.variants[style&CodeSpan]; hasVariant {
+		return font
+	}
+	return ttf
 }
 
 // Measures the size of a font
@@ -0,0 +1,125 @@
+package graphbox
+
+// GroupBoxStyle defines the style of the boxes drawn around groups of actors
+type GroupBoxStyle struct {
+	Font     Font
+	FontSize int
+
+	// Padding between the edge of the box and the actors within it
+	Padding Point
+
+	// Gap between the title and the actors
+	TitleGap int
+
+	// The background color
+	Color string
+}
+
+// GroupBox is a titled, shaded box drawn behind a group of actors.  It spans from the
+// header row to the footer row and from the column of the first actor to the column of
+// the last actor.
+//
+// The box needs to be drawn before the actors so it is put before them.  The space it
+// requires depends on the actors within it, so its constraints are applied by the item
+// returned by Layout, which should be put after everything else.
+type GroupBox struct {
+	TR, TC int
+
+	// The extent of the actors within the box.  The left and right ends are relative to
+	// the first and last columns.
+	Ends LineEnds
+
+	// The extent of the actors above the header row and below the footer row
+	Top, Bottom int
+
+	// The space to keep between the box and the columns on either side of it.  Used to
+	// prevent the box from overlapping neighbouring actors.
+	LeftGap, RightGap int
+
+	style    GroupBoxStyle
+	textBox  *TextBox
+	textRect Rect
+}
+
+// NewGroupBox creates a new group box
+func NewGroupBox(toRow, toCol int, title string, style GroupBoxStyle) *GroupBox {
+	textBox := NewTextBox(style.Font, style.FontSize, MiddleTextAlign)
+	textBox.AddText(title)
+
+	textRect := textBox.BoundingRect()
+	if title == "" {
+		textRect = Rect{}
+	}
+
+	return &GroupBox{TR: toRow, TC: toCol, style: style, textBox: textBox, textRect: textRect}
+}
+
+// Constraint does nothing.  See Layout.
+func (gb *GroupBox) Constraint(r, c int, applier ConstraintApplier) {
+}
+
+// Layout returns an item which applies the constraints of the group box.  It should be put at
+// the same position as the box.
+func (gb *GroupBox) Layout() GraphboxItem {
+	return groupBoxLayout{gb}
+}
+
+func (gb *GroupBox) Draw(ctx DrawContext, point Point) {
+	toPoint, isPoint := ctx.PointAt(gb.TR, gb.TC)
+	if !isPoint {
+		return
+	}
+
+	fx := point.X + gb.Ends.Left - gb.style.Padding.X
+	tx := toPoint.X + gb.Ends.Right + gb.style.Padding.X
+	if minWidth := gb.textRect.W + gb.style.Padding.X*2; tx-fx < minWidth {
+		fx -= (minWidth - (tx - fx)) / 2
+		tx = fx + minWidth
+	}
+
+	fy := point.Y - gb.Top - gb.titleHeight()
+	ty := toPoint.Y + gb.Bottom + gb.style.Padding.Y
+
+	s := SvgStyle{}
+	s.Set("stroke", "none")
+	s.Set("fill", gb.style.Color)
+	ctx.Canvas.Rect(fx, fy, tx-fx, ty-fy, s.ToStyle())
+
+	if gb.textRect.H > 0 {
+		gb.textBox.Render(ctx.Canvas, (fx+tx)/2, fy+gb.style.Padding.Y, NorthGravity)
+	}
+}
+
+// The height of the box above the top of the actors
+func (gb *GroupBox) titleHeight() int {
+	h := gb.style.Padding.Y
+	if gb.textRect.H > 0 {
+		h += gb.textRect.H + gb.style.TitleGap
+	}
+	return h
+}
+
+// Applies the constraints of a group box
+type groupBoxLayout struct {
+	box *GroupBox
+}
+
+func (gl groupBoxLayout) Constraint(r, c int, applier ConstraintApplier) {
+	gb := gl.box
+	padX := gb.style.Padding.X
+
+	applier.Apply(SizeConstraint{r, c, 0, 0, gb.Top + gb.titleHeight(), 0})
+	applier.Apply(SizeConstraint{gb.TR, c, 0, 0, 0, gb.Bottom + gb.style.Padding.Y})
+
+	if gb.TC > c {
+		applier.Apply(SizeConstraint{r, c, -gb.Ends.Left + padX + gb.LeftGap, 0, 0, 0})
+		applier.Apply(SizeConstraint{r, gb.TC, 0, gb.Ends.Right + padX + gb.RightGap, 0, 0})
+		applier.Apply(TotalSizeConstraint{r, c, r, gb.TC, gb.textRect.W + gb.Ends.Left - gb.Ends.Right, 0})
+	} else {
+		halfWidth := maxInt(gb.textRect.W/2, maxInt(-gb.Ends.Left, gb.Ends.Right)) + padX
+		applier.Apply(SizeConstraint{r, c, halfWidth + gb.LeftGap, halfWidth + gb.RightGap, 0, 0})
+	}
+}
+
+func (gl groupBoxLayout) Draw(ctx DrawContext, point Point) {
+}
@@ -172,3 +172,90 @@ var CloudPathData = PathIconData{
 		60.25-59.71 0-32.972-26.97-59.703-60.25-59.703z
 	`,
 }
+
+// A UML boundary: a circle joined to a vertical bar on its left
+//
+
+type BoundaryIcon struct {
+	Radius    int
+	Connector int
+}
+
+func (bi BoundaryIcon) Size() (width int, height int) {
+	return bi.Connector + bi.Radius*2, bi.Radius * 2
+}
+
+func (bi BoundaryIcon) Draw(ctx DrawContext, x int, y int, lineStyle *SvgStyle) {
+	style := lineStyle.ToStyle()
+
+	w, _ := bi.Size()
+	barX := x - w/2
+	circleX := barX + bi.Connector + bi.Radius
+
+	ctx.Canvas.Line(barX, y-bi.Radius, barX, y+bi.Radius, style)
+	ctx.Canvas.Line(barX, y, barX+bi.Connector, y, style)
+	ctx.Canvas.Circle(circleX, y, bi.Radius, style)
+}
+
+// A UML control: a circle with an arrow head at the top
+//
+
+type ControlIcon struct {
+	Radius    int
+	ArrowSize int
+}
+
+func (ci ControlIcon) Size() (width int, height int) {
+	return ci.Radius * 2, (ci.Radius + ci.ArrowSize) * 2
+}
+
+func (ci ControlIcon) Draw(ctx DrawContext, x int, y int, lineStyle *SvgStyle) {
+	style := lineStyle.ToStyle()
+
+	ctx.Canvas.Circle(x, y, ci.Radius, style)
+	ctx.Canvas.Polyline(
+		[]int{x + ci.ArrowSize, x, x + ci.ArrowSize},
+		[]int{y - ci.Radius - ci.ArrowSize, y - ci.Radius, y - ci.Radius + ci.ArrowSize},
+		style)
+}
+
+// A UML entity: a circle with a line underneath
+//
+
+type EntityIcon struct {
+	Radius int
+}
+
+func (ei EntityIcon) Size() (width int, height int) {
+	return ei.Radius * 2, ei.Radius*2 + 2
+}
+
+func (ei EntityIcon) Draw(ctx DrawContext, x int, y int, lineStyle *SvgStyle) {
+	style := lineStyle.ToStyle()
+
+	ctx.Canvas.Circle(x, y-1, ei.Radius, style)
+	ctx.Canvas.Line(x-ei.Radius, y+ei.Radius+1, x+ei.Radius, y+ei.Radius+1, style)
+}
+
+// A stack of boxes suggesting a collection of participants
+//
+
+type CollectionsIcon struct {
+	Width  int
+	Height int
+	Offset int
+}
+
+func (ci CollectionsIcon) Size() (width int, height int) {
+	return ci.Width + ci.Offset, ci.Height + ci.Offset
+}
+
+func (ci CollectionsIcon) Draw(ctx DrawContext, x int, y int, lineStyle *SvgStyle) {
+	style := lineStyle.ToStyle()
+
+	w, h := ci.Size()
+	left, top := x-w/2, y-h/2
+
+	ctx.Canvas.Rect(left+ci.Offset, top, ci.Width, ci.Height, style)
+	ctx.Canvas.Rect(left, top+ci.Offset, ci.Width, ci.Height, style)
+}
@@ -26,3 +26,33 @@ func (ll *LifeLine) Draw(ctx DrawContext, point Point) {
 		ctx.Canvas.Line(fx, fy, tx, ty, s.ToStyle())
 	}
 }
+
+// DestroyMarkerStyle defines the style of the destroy marker
+type DestroyMarkerStyle struct {
+	// The width and height of the marker
+	Size int
+
+	Color string
+}
+
+// DestroyMarker is the cross drawn at the end of the lifeline of a destroyed actor
+type DestroyMarker struct {
+	Style DestroyMarkerStyle
+}
+
+func (dm *DestroyMarker) Constraint(r, c int, applier ConstraintApplier) {
+	halfSize := dm.Style.Size / 2
+	applier.Apply(SizeConstraint{r, c, halfSize, halfSize, halfSize, halfSize})
+}
+
+func (dm *DestroyMarker) Draw(ctx DrawContext, point Point) {
+	s := SvgStyle{}
+	s.Set("stroke", dm.Style.Color)
+	s.Set("stroke-width", "3px")
+
+	halfSize := dm.Style.Size / 2
+	x, y := point.X, point.Y
+
+	ctx.Canvas.Line(x-halfSize, y-halfSize, x+halfSize, y+halfSize, s.ToStyle())
+	ctx.Canvas.Line(x-halfSize, y+halfSize, x+halfSize, y-halfSize, s.ToStyle())
+}
@@ -0,0 +1,216 @@
+package graphbox
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The styling of a span of text.  Styles can be combined.
+type SpanStyle int
+
+const (
+	BoldSpan   SpanStyle = 1 << iota
+	ItalicSpan           = 1 << iota
+	CodeSpan             = 1 << iota
+	StrikeSpan           = 1 << iota
+
+	PlainSpan SpanStyle = 0
+)
+
+// The characters which can be escaped with a backslash
+const markupEscapes = "\\*`~[]"
+
+// A run of text with a single style
+type TextSpan struct {
+	Text  string
+	Style SpanStyle
+
+	// The target of the link if the span is a link
+	Link string
+}
+
+// Parses a line of inline markup into a list of spans.  The following markup is recognised:
+//
+//	**bold**  *italic*  `code`  ~~strike~~  [link](url)
+//
+// A delimiter only starts a styled span if it is followed by a non-space character and
+// there is a matching delimiter preceded by a non-space character later on the line.
+// Otherwise it is treated as literal text.  A backslash escapes any of the markup characters.
+func ParseMarkup(line string) []TextSpan {
+	mp := &markupParser{}
+	mp.parse(line, PlainSpan, "")
+	mp.flush()
+
+	if len(mp.spans) == 0 {
+		return []TextSpan{{"", PlainSpan, ""}}
+	}
+	return mp.spans
+}
+
+type markupParser struct {
+	spans []TextSpan
+	text  strings.Builder
+	style SpanStyle
+	link  string
+}
+
+// Parses the text, appending spans with the given base style and link
+func (mp *markupParser) parse(line string, style SpanStyle, link string) {
+	for i := 0; i < len(line); {
+		rest := line[i:]
+
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.IndexByte(markupEscapes, rest[1]) >= 0:
+			mp.write(rest[1:2], style, link)
+			i += 2
+			continue
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				mp.write(rest[1:1+end], style|CodeSpan, link)
+				i += end + 2
+				continue
+			}
+		case rest[0] == '[' && link == "":
+			if text, url, size := scanMarkupLink(rest); size > 0 {
+				mp.parse(text, style, url)
+				i += size
+				continue
+			}
+		default:
+			if delim, delimStyle := markupDelimiter(rest); delim != "" {
+				if end := findClosingDelimiter(rest, delim); end > 0 {
+					mp.parse(rest[len(delim):end], style|delimStyle, link)
+					i += end + len(delim)
+					continue
+				}
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(rest)
+		mp.write(rest[:size], style, link)
+		i += size
+	}
+}
+
+// Appends text with the given style, starting a new span if the style changes
+func (mp *markupParser) write(text string, style SpanStyle, link string) {
+	if mp.text.Len() > 0 && (style != mp.style || link != mp.link) {
+		mp.flush()
+	}
+	mp.style, mp.link = style, link
+	mp.text.WriteString(text)
+}
+
+// Finishes the current span
+func (mp *markupParser) flush() {
+	if mp.text.Len() > 0 {
+		mp.spans = append(mp.spans, TextSpan{mp.text.String(), mp.style, mp.link})
+		mp.text.Reset()
+	}
+}
+
+// Returns the delimiter at the start of the text, if any
+func markupDelimiter(text string) (string, SpanStyle) {
+	switch {
+	case strings.HasPrefix(text, "**"):
+		return "**", BoldSpan
+	case strings.HasPrefix(text, "~~"):
+		return "~~", StrikeSpan
+	case strings.HasPrefix(text, "*"):
+		return "*", ItalicSpan
+	}
+	return "", PlainSpan
+}
+
+// Returns the index of the delimiter closing the one at the start of the text, or -1 if
+// the delimiter is not closed.
+func findClosingDelimiter(text string, delim string) int {
+	first, _ := utf8.DecodeRuneInString(text[len(delim):])
+	if first == utf8.RuneError || unicode.IsSpace(first) {
+		return -1
+	}
+
+	for i := len(delim) + 1; i+len(delim) <= len(text); i++ {
+		if text[i] == '\\' {
+			i++
+			continue
+		}
+		if !strings.HasPrefix(text[i:], delim) {
+			continue
+		}
+
+		// A single "*" should not close on the first half of a "**"
+		if delim == "*" && strings.HasPrefix(text[i:], "**") {
+			i++
+			continue
+		}
+
+		last, _ := utf8.DecodeLastRuneInString(text[:i])
+		if !unicode.IsSpace(last) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Scans a link of the form "[text](url)" at the start of the text.  Returns the size of the
+// link in bytes, or 0 if the text does not start with a link.
+func scanMarkupLink(text string) (string, string, int) {
+	textEnd := strings.Index(text, "](")
+	if textEnd < 2 {
+		return "", "", 0
+	}
+
+	urlEnd := strings.IndexByte(text[textEnd+2:], ')')
+	if urlEnd < 1 {
+		return "", "", 0
+	}
+
+	url := text[textEnd+2 : textEnd+2+urlEnd]
+	if strings.ContainsAny(url, " \t") {
+		return "", "", 0
+	}
+	return text[1:textEnd], url, textEnd + 3 + urlEnd
+}
+
+// Splits a line of spans into words at each space.  Consecutive spaces produce empty words.
+func splitWords(line []TextSpan) [][]TextSpan {
+	words := [][]TextSpan{nil}
+	for _, span := range line {
+		for i, part := range strings.Split(span.Text, " ") {
+			if i > 0 {
+				words = append(words, nil)
+			}
+			if part != "" {
+				words[len(words)-1] = append(words[len(words)-1], TextSpan{part, span.Style, span.Link})
+			}
+		}
+	}
+	return words
+}
+
+// Returns the space to put between a line and the word following it.  The space keeps the
+// style of the surrounding text if it is the same on both sides.
+func wordSpace(line []TextSpan, word []TextSpan) TextSpan {
+	space := TextSpan{" ", PlainSpan, ""}
+	if len(line) > 0 && len(word) > 0 {
+		before, after := line[len(line)-1], word[0]
+		if before.Style == after.Style && before.Link == after.Link {
+			space.Style, space.Link = before.Style, before.Link
+		}
+	}
+	return space
+}
+
+// Appends spans to a line, merging spans with the same style
+func appendSpans(line []TextSpan, spans ...TextSpan) []TextSpan {
+	for _, span := range spans {
+		if n := len(line); n > 0 && line[n-1].Style == span.Style && line[n-1].Link == span.Link {
+			line[n-1].Text += span.Text
+		} else {
+			line = append(line, span)
+		}
+	}
+	return line
+}
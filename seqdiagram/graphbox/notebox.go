@@ -3,9 +3,21 @@ package graphbox
 type NoteBoxPos int
 
 const (
-	CenterNotePos NoteBoxPos = iota
-	LeftNotePos              = iota
-	RightNotePos             = iota
+	CenterNotePos  NoteBoxPos = iota
+	LeftNotePos               = iota
+	RightNotePos              = iota
+	SpanNotePos               = iota
+	MessageNotePos            = iota
+)
+
+// The shape of the frame drawn around a note
+type NoteShape int
+
+const (
+	RectNoteShape    NoteShape = iota
+	FoldedNoteShape            = iota
+	RoundedNoteShape           = iota
+	HexagonNoteShape           = iota
 )
 
 // Styling options for the actor rect
@@ -15,26 +27,82 @@ type NoteBoxStyle struct {
 	Padding  Point
 	Margin   Point
 	Position NoteBoxPos
+
+	// The shape of the note frame and the size of its folded or rounded corners
+	Shape      NoteShape
+	CornerSize int
+
+	// The distance notes spanning several columns extend beyond the first and last column
+	Overlap int
+
+	// The length of the line connecting notes on messages to the message arrow
+	ConnectorLength int
+
+	// The width at which text is wrapped.  Zero disables wrapping.
+	MaxTextWidth int
+
+	// The fill colour of the frame.  Defaults to white.
+	Color string
 }
 
-// Draws an object instance
+// Draws a note.  Notes are either drawn next to or over a single column or, if created with
+// NewSpanningNoteBox, over a range of columns.  Notes created with NewMessageNoteBox are drawn
+// next to the end of an activity line.
 type NoteBox struct {
+	// The last column spanned by the note.  Only used by spanning notes.
+	TC int
+
 	frameRect Rect
 	style     NoteBoxStyle
 	textBox   *TextBox
 	pos       NoteBoxPos
+
+	// The horizontal distance between the frame and the start of the padding, taken up
+	// by the points of hexagon notes
+	shapeInset int
+
+	// The distance spanning notes extend beyond the first and last column.  Notes
+	// spanning to the edge of the diagram do not extend beyond it.
+	leftOverlap, rightOverlap int
+
+	// The line a message note is attached to and the column the line was put in
+	line    *ActivityLine
+	lineCol int
 }
 
 func NewNoteBox(text string, style NoteBoxStyle, pos NoteBoxPos) *NoteBox {
 	var textAlign TextAlign = MiddleTextAlign
 
 	textBox := NewTextBox(style.Font, style.FontSize, textAlign)
-	textBox.AddText(text)
+	textBox.MaxWidth = style.MaxTextWidth
+	textBox.AddMarkup(text)
 
 	trect := textBox.BoundingRect()
 	brect := trect.BlowOut(style.Padding)
 
-	return &NoteBox{brect, style, textBox, pos}
+	shapeInset := 0
+	if style.Shape == HexagonNoteShape {
+		shapeInset = minInt(brect.H/2, style.CornerSize)
+		brect = brect.AddSize(shapeInset*2, 0)
+	}
+
+	return &NoteBox{0, brect, style, textBox, pos, shapeInset, 0, 0, nil, 0}
+}
+
+// NewSpanningNoteBox creates a note drawn over the columns from the one it is put in to toCol
+func NewSpanningNoteBox(toCol int, text string, style NoteBoxStyle) *NoteBox {
+	note := NewNoteBox(text, style, SpanNotePos)
+	note.TC = toCol
+	return note
+}
+
+// NewMessageNoteBox creates a note attached to an activity line put in the column lineCol.  The
+// note should be put in the same row as the line and the column the line ends in.  It shares
+// the row with the line rather than adding to its height.
+func NewMessageNoteBox(line *ActivityLine, lineCol int, text string, style NoteBoxStyle) *NoteBox {
+	note := NewNoteBox(text, style, MessageNotePos)
+	note.line, note.lineCol = line, lineCol
+	return note
 }
 
 func (tr *NoteBox) Constraint(r, c int, applier ConstraintApplier) {
@@ -42,7 +110,28 @@ func (tr *NoteBox) Constraint(r, c int, applier ConstraintApplier) {
 
 	marginX := tr.style.Margin.X
 	marginY := tr.style.Margin.Y
-	if tr.pos == LeftNotePos {
+	if tr.pos == MessageNotePos {
+		right, offset := tr.line.noteAnchor(tr.lineCol)
+		width := offset + tr.style.ConnectorLength + tr.frameRect.W + marginX
+		if right {
+			applier.Apply(SizeConstraint{r, c, 0, width, tr.frameRect.H/2 + marginY, tr.frameRect.H/2 + marginY})
+		} else {
+			applier.Apply(SizeConstraint{r, c, width, 0, tr.frameRect.H/2 + marginY, tr.frameRect.H/2 + marginY})
+		}
+		return
+	} else if tr.pos == SpanNotePos {
+		tr.leftOverlap, tr.rightOverlap = tr.style.Overlap, tr.style.Overlap
+		if c == 0 {
+			tr.leftOverlap = 0
+		}
+		if tr.TC == applier.Cols()-1 {
+			tr.rightOverlap = 0
+		}
+
+		applier.Apply(SizeConstraint{r, c, tr.leftOverlap, 0, 0, 0})
+		applier.Apply(SizeConstraint{r, tr.TC, 0, tr.rightOverlap, 0, 0})
+		horizConstraint = TotalSizeConstraint{r - 1, c, r, tr.TC, tr.frameRect.W + marginX*2 - (tr.leftOverlap + tr.rightOverlap), 0}
+	} else if tr.pos == LeftNotePos {
 		horizConstraint = SizeConstraint{r, c, tr.frameRect.W + marginX*2, marginX, 0, 0}
 	} else if tr.pos == RightNotePos {
 		horizConstraint = SizeConstraint{r, c, marginX, tr.frameRect.W + marginX*2, 0, 0}
@@ -60,19 +149,76 @@ func (r *NoteBox) Draw(ctx DrawContext, point Point) {
 
 	if r.pos == CenterNotePos {
 		rect := r.frameRect.PositionAt(centerX, centerY, CenterGravity)
-		ctx.Canvas.Rect(rect.X, rect.Y, rect.W, rect.H, "stroke:black;fill:white;stroke-width:2px;")
+		r.drawFrame(ctx, rect)
 		r.textBox.Render(ctx.Canvas, centerX, centerY, CenterGravity)
 	} else if r.pos == LeftNotePos {
 		offsetX := centerX - marginX
-		textOffsetX := centerX - r.style.Padding.X - marginX
+		textOffsetX := centerX - r.style.Padding.X - marginX - r.shapeInset
 		rect := r.frameRect.PositionAt(offsetX, centerY, EastGravity)
-		ctx.Canvas.Rect(rect.X, rect.Y, rect.W, rect.H, "stroke:black;fill:white;stroke-width:2px;")
+		r.drawFrame(ctx, rect)
 		r.textBox.Render(ctx.Canvas, textOffsetX, centerY, EastGravity)
 	} else if r.pos == RightNotePos {
 		offsetX := centerX + marginX
-		textOffsetX := centerX + r.style.Padding.X + marginX
+		textOffsetX := centerX + r.style.Padding.X + marginX + r.shapeInset
 		rect := r.frameRect.PositionAt(offsetX, centerY, WestGravity)
-		ctx.Canvas.Rect(rect.X, rect.Y, rect.W, rect.H, "stroke:black;fill:white;stroke-width:2px;")
+		r.drawFrame(ctx, rect)
 		r.textBox.Render(ctx.Canvas, textOffsetX, centerY, WestGravity)
+	} else if r.pos == SpanNotePos {
+		toPoint, isPoint := ctx.PointAt(ctx.R, r.TC)
+		if !isPoint {
+			return
+		}
+
+		fx, tx := centerX-r.leftOverlap, toPoint.X+r.rightOverlap
+		rect := Rect{fx, centerY - r.frameRect.H/2, tx - fx, r.frameRect.H}
+		r.drawFrame(ctx, rect)
+		r.textBox.Render(ctx.Canvas, fx+(tx-fx)/2, centerY, CenterGravity)
+	} else if r.pos == MessageNotePos {
+		right, offset := r.line.noteAnchor(r.lineCol)
+		lineX, frameX := centerX-offset, centerX-offset-r.style.ConnectorLength
+		gravity := EastGravity
+		if right {
+			lineX, frameX = centerX+offset, centerX+offset+r.style.ConnectorLength
+			gravity = WestGravity
+		}
+
+		ctx.Canvas.Line(lineX, centerY, frameX, centerY, "stroke:black;stroke-width:1px;stroke-dasharray:2,2;")
+
+		rect := r.frameRect.PositionAt(frameX, centerY, gravity)
+		r.drawFrame(ctx, rect)
+		r.textBox.Render(ctx.Canvas, rect.X+rect.W/2, centerY, CenterGravity)
+	}
+}
+
+// Draws the frame of the note in the given rectangle
+func (r *NoteBox) drawFrame(ctx DrawContext, rect Rect) {
+	frameStyle := "stroke:black;fill:white;stroke-width:2px;"
+	if r.style.Color != "" {
+		frameStyle = "stroke:black;fill:" + r.style.Color + ";stroke-width:2px;"
+	}
+	corner := minInt(r.style.CornerSize, minInt(rect.W, rect.H)/2)
+
+	switch r.style.Shape {
+	case FoldedNoteShape:
+		left, top, right, bottom := rect.X, rect.Y, rect.X+rect.W, rect.Y+rect.H
+		ctx.Canvas.Polygon(
+			[]int{left, right - corner, right, right, left},
+			[]int{top, top, top + corner, bottom, bottom},
+			frameStyle)
+		ctx.Canvas.Polyline(
+			[]int{right - corner, right - corner, right},
+			[]int{top, top + corner, top + corner},
+			"stroke:black;fill:none;stroke-width:2px;")
+	case RoundedNoteShape:
+		ctx.Canvas.Roundrect(rect.X, rect.Y, rect.W, rect.H, corner, corner, frameStyle)
+	case HexagonNoteShape:
+		inset := minInt(rect.H/2, r.style.CornerSize)
+		left, top, right, bottom, midY := rect.X, rect.Y, rect.X+rect.W, rect.Y+rect.H, rect.Y+rect.H/2
+		ctx.Canvas.Polygon(
+			[]int{left, left + inset, right - inset, right, right - inset, left + inset},
+			[]int{midY, top, top, midY, bottom, bottom},
+			frameStyle)
+	default:
+		ctx.Canvas.Rect(rect.X, rect.Y, rect.W, rect.H, frameStyle)
 	}
 }
@@ -0,0 +1,108 @@
+package graphbox
+
+// RefStyle defines the style of a reference frame
+type RefStyle struct {
+	Font     Font
+	FontSize int
+
+	// Padding around the message
+	Padding Point
+
+	// Margin around the frame
+	Margin Point
+
+	// Padding around the prefix label
+	TextPadding      Point
+	PrefixExtraWidth int
+
+	// The amount the frame extends beyond the outer actors
+	Overlap int
+}
+
+// RefFrame is a framed reference to another interaction.  It is labelled with a "ref" prefix
+// and spans the columns between the actors it is drawn over.
+type RefFrame struct {
+	TC int
+
+	style RefStyle
+
+	prefixTextBox  *TextBox
+	prefixRect     Rect
+	messageTextBox *TextBox
+	messageRect    Rect
+}
+
+// NewRefFrame creates a new reference frame
+func NewRefFrame(toCol int, text string, style RefStyle) *RefFrame {
+	prefixTextBox := NewTextBox(style.Font, style.FontSize, MiddleTextAlign)
+	prefixTextBox.AddText("ref")
+	prefixRect := prefixTextBox.BoundingRect().BlowOut(style.TextPadding).AddSize(style.PrefixExtraWidth, 0)
+
+	messageTextBox := NewTextBox(style.Font, style.FontSize, MiddleTextAlign)
+	messageTextBox.AddMarkup(text)
+	messageRect := messageTextBox.BoundingRect()
+
+	return &RefFrame{toCol, style, prefixTextBox, prefixRect, messageTextBox, messageRect}
+}
+
+// Returns the size of the frame
+func (rf *RefFrame) frameSize() (int, int) {
+	w := maxInt(rf.prefixRect.W, rf.messageRect.W) + rf.style.Padding.X*2
+	h := rf.prefixRect.H + rf.messageRect.H + rf.style.Padding.Y*2
+	return w, h
+}
+
+func (rf *RefFrame) Constraint(r, c int, applier ConstraintApplier) {
+	w, h := rf.frameSize()
+
+	applier.Apply(AddSizeConstraint{r, c, 0, 0, h/2 + rf.style.Margin.Y, h/2 + rf.style.Margin.Y})
+
+	if c == rf.TC {
+		applier.Apply(SizeConstraint{r, c, w/2 + rf.style.Margin.X, w/2 + rf.style.Margin.X, 0, 0})
+	} else {
+		leftOverlap, rightOverlap := rf.overlaps(c, applier.Cols())
+
+		applier.Apply(SizeConstraint{r, c, leftOverlap + rf.style.Margin.X, 0, 0, 0})
+		applier.Apply(SizeConstraint{r, rf.TC, 0, rightOverlap + rf.style.Margin.X, 0, 0})
+		applier.Apply(TotalSizeConstraint{r - 1, c, r, rf.TC, w - (leftOverlap + rightOverlap), 0})
+	}
+}
+
+// Returns the amount the frame extends beyond the left and right columns.  Frames do not
+// extend beyond the edge of the diagram.
+func (rf *RefFrame) overlaps(c int, cols int) (int, int) {
+	leftOverlap, rightOverlap := rf.style.Overlap, rf.style.Overlap
+	if c == 0 {
+		leftOverlap = 0
+	}
+	if rf.TC == cols-1 {
+		rightOverlap = 0
+	}
+	return leftOverlap, rightOverlap
+}
+
+func (rf *RefFrame) Draw(ctx DrawContext, point Point) {
+	fx, fy := point.X, point.Y
+	if point, isPoint := ctx.PointAt(ctx.R, rf.TC); isPoint {
+		w, h := rf.frameSize()
+		tx := point.X
+
+		if ctx.C == rf.TC {
+			fx, tx = fx-w/2, fx+w/2
+		} else {
+			leftOverlap, rightOverlap := rf.overlaps(ctx.C, ctx.Graphic.Cols())
+			fx, tx = fx-leftOverlap, tx+rightOverlap
+		}
+
+		frameRect := Rect{fx, fy - h/2, tx - fx, h}
+		ctx.Canvas.Rect(frameRect.X, frameRect.Y, frameRect.W, frameRect.H, "stroke:black;fill:white;stroke-width:2px;")
+
+		ptr := rf.prefixRect.PositionAt(frameRect.X, frameRect.Y, NorthWestGravity)
+		drawPrefixFrame(ctx, ptr.X, ptr.Y, ptr.X+ptr.W, ptr.Y+ptr.H, rf.style.FontSize/2)
+		rf.prefixTextBox.Render(ctx.Canvas, ptr.X+rf.style.TextPadding.X, ptr.Y+rf.style.TextPadding.Y, NorthWestGravity)
+
+		centerX := frameRect.X + frameRect.W/2
+		messageY := frameRect.Y + ptr.H + rf.style.Padding.Y
+		rf.messageTextBox.Render(ctx.Canvas, centerX, messageY, NorthGravity)
+	}
+}
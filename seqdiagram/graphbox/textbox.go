@@ -11,6 +11,9 @@ const (
 	LINE_GAP = 2
 )
 
+// The color of linked text
+const linkColor = "blue"
+
 type TextAlign int
 
 const (
@@ -19,29 +22,77 @@ const (
 	RightTextAlign            = iota
 )
 
-// A block of prose
+// A block of prose.  Each line is made up of one or more spans of styled text.
 type TextBox struct {
-	Lines    []string
+	Lines    [][]TextSpan
 	Font     Font
 	FontSize int
 	Align    TextAlign
 
 	Color string
+
+	// The width at which added text is wrapped.  Zero disables wrapping.
+	MaxWidth int
 }
 
 // Returns a new text box
 func NewTextBox(font Font, fontSize int, align TextAlign) *TextBox {
 	return &TextBox{
-		Lines:    make([]string, 0),
+		Lines:    make([][]TextSpan, 0),
 		Font:     font,
 		FontSize: fontSize,
 		Align:    align,
 	}
 }
 
-// Adds some text
+// Adds some plain text
 func (tb *TextBox) AddText(text string) {
-	tb.Lines = append(tb.Lines, strings.Split(text, "\n")...)
+	for _, line := range strings.Split(text, "\n") {
+		tb.addLine([]TextSpan{{line, PlainSpan, ""}})
+	}
+}
+
+// Adds some text containing inline markup.  See ParseMarkup.
+func (tb *TextBox) AddMarkup(text string) {
+	for _, line := range strings.Split(text, "\n") {
+		tb.addLine(ParseMarkup(line))
+	}
+}
+
+// Adds a line, wrapping it if it is wider than the maximum width
+func (tb *TextBox) addLine(line []TextSpan) {
+	if tb.MaxWidth <= 0 {
+		tb.Lines = append(tb.Lines, line)
+		return
+	}
+	if w, _ := tb.measureLine(line); w <= tb.MaxWidth {
+		tb.Lines = append(tb.Lines, line)
+		return
+	}
+
+	// Words are broken at spaces and may be made up of spans with different styles.  Words
+	// which are wider than the maximum width are left on a line of their own.
+	var wrapped []TextSpan
+	for i, word := range splitWords(line) {
+		if i == 0 {
+			wrapped = appendSpans(wrapped, word...)
+			continue
+		}
+
+		candidate := appendSpans(append([]TextSpan{}, wrapped...), wordSpace(wrapped, word))
+		candidate = appendSpans(candidate, word...)
+		if w, _ := tb.measureLine(candidate); w > tb.MaxWidth && len(wrapped) > 0 {
+			tb.Lines = append(tb.Lines, wrapped)
+			wrapped = appendSpans(nil, word...)
+		} else {
+			wrapped = candidate
+		}
+	}
+
+	if len(wrapped) == 0 {
+		wrapped = []TextSpan{{"", PlainSpan, ""}}
+	}
+	tb.Lines = append(tb.Lines, wrapped)
 }
 
 // Returns the width and height of the text box.
@@ -58,10 +109,20 @@ func (tb *TextBox) Measure() (int, int) {
 	return w, h - LINE_GAP
 }
 
-// Measures a line
-func (tb *TextBox) measureLine(line string) (int, int) {
+// Measures a line.  Each span is measured using the font variant for its style.
+func (tb *TextBox) measureLine(line []TextSpan) (int, int) {
 	fs := float64(tb.FontSize)
-	return tb.Font.Measure(line, fs)
+	if len(line) == 1 {
+		return fontVariant(tb.Font, line[0].Style).Measure(line[0].Text, fs)
+	}
+
+	w, h := 0, 0
+	for _, span := range line {
+		sw, sh := fontVariant(tb.Font, span.Style).Measure(span.Text, fs)
+		w += sw
+		h = maxInt(h, sh)
+	}
+	return w, h
 }
 
 // Given a font, font size, points and gravity, returns a rectangle which will contain
@@ -98,14 +159,81 @@ func (tb *TextBox) Render(svg *svg.SVG, x, y int, gravity Gravity) {
 
 		textBottom := currY + lineH - (tb.FontSize*1/4 - 1)
 
-		if line != "" {
-			svg.Text(textLeft, textBottom, line, style)
+		attrs := []string{style}
+		if hasSignificantSpace(line) {
+			attrs = append(attrs, `xml:space="preserve"`)
+		}
+
+		if len(line) == 1 && line[0].Style == PlainSpan && line[0].Link == "" {
+			if line[0].Text != "" {
+				svg.Text(textLeft, textBottom, line[0].Text, attrs...)
+			}
+		} else {
+			tb.renderSpans(svg, textLeft, textBottom, line, attrs)
 		}
 
 		currY += lineH + LINE_GAP
 	}
 }
 
+// Renders a line of styled spans as a text element with a tspan for each span
+func (tb *TextBox) renderSpans(svg *svg.SVG, x, y int, line []TextSpan, attrs []string) {
+	fmt.Fprintf(svg.Writer, `<text x="%d" y="%d" style="%s" `, x, y, attrs[0])
+	for _, attr := range attrs[1:] {
+		fmt.Fprint(svg.Writer, attr+" ")
+	}
+	fmt.Fprint(svg.Writer, ">")
+	for _, span := range line {
+		if span.Link != "" {
+			fmt.Fprintf(svg.Writer, `<a xlink:href="%s">`, escapeXML(span.Link))
+		}
+		fmt.Fprintf(svg.Writer, `<tspan style="%s">%s</tspan>`, tb.spanStyle(span), escapeXML(span.Text))
+		if span.Link != "" {
+			fmt.Fprint(svg.Writer, `</a>`)
+		}
+	}
+	fmt.Fprintln(svg.Writer, `</text>`)
+}
+
+// Returns true if the line has leading or repeated spaces, such as indented lines of a text
+// block, which would be collapsed unless preserved.
+func hasSignificantSpace(line []TextSpan) bool {
+	text := ""
+	for _, span := range line {
+		text += span.Text
+	}
+	return strings.HasPrefix(text, " ") || strings.Contains(text, "  ")
+}
+
+// Returns the styling of a single span
+func (tb *TextBox) spanStyle(span TextSpan) string {
+	s := SvgStyle{}
+
+	if span.Style&BoldSpan != 0 {
+		s.Set("font-weight", "bold")
+	}
+	if span.Style&ItalicSpan != 0 {
+		s.Set("font-style", "italic")
+	}
+	if span.Style&CodeSpan != 0 {
+		s.Set("font-family", "monospace")
+	}
+
+	decorations := make([]string, 0)
+	if span.Link != "" {
+		s.Set("fill", linkColor)
+		decorations = append(decorations, "underline")
+	}
+	if span.Style&StrikeSpan != 0 {
+		decorations = append(decorations, "line-through")
+	}
+	if len(decorations) > 0 {
+		s.Set("text-decoration", strings.Join(decorations, " "))
+	}
+
+	return s.ToStyle()
+}
+
 // Returns the text styling
 func (tb *TextBox) textStyle() string {
 	s := SvgStyle{}
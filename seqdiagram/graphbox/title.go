@@ -16,7 +16,7 @@ type Title struct {
 
 func NewTitle(toCol int, text string, style TitleStyle) *Title {
 	textBox := NewTextBox(style.Font, style.FontSize, LeftTextAlign)
-	textBox.AddText(text)
+	textBox.AddMarkup(text)
 
 	brect := textBox.BoundingRect()
 	return &Title{toCol, style, textBox, brect}
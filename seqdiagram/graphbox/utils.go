@@ -1,5 +1,10 @@
 package graphbox
 
+import (
+	"bytes"
+	"encoding/xml"
+)
+
 // Returns the maximum of two integer.
 func maxInt(x, y int) int {
 	if x > y {
@@ -8,3 +13,19 @@ func maxInt(x, y int) int {
 		return y
 	}
 }
+
+// Returns the minimum of two integer.
+func minInt(x, y int) int {
+	if x < y {
+		return x
+	} else {
+		return y
+	}
+}
+
+// Returns the text with XML special characters escaped
+func escapeXML(text string) string {
+	buf := &bytes.Buffer{}
+	xml.EscapeText(buf, []byte(text))
+	return buf.String()
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/lmika/goseq/seqdiagram/graphbox"
 )
@@ -20,12 +22,22 @@ var graphboxArrowStemMapping = map[ArrowStem]graphbox.ActivityArrowStem{
 	ThickArrowStem:  graphbox.ThickArrowStem,
 }
 
+var graphboxNoteShapeMapping = map[NoteShape]graphbox.NoteShape{
+	RectNoteShape:    graphbox.RectNoteShape,
+	FoldedNoteShape:  graphbox.FoldedNoteShape,
+	RoundedNoteShape: graphbox.RoundedNoteShape,
+	HexagonNoteShape: graphbox.HexagonNoteShape,
+}
+
 // Load the internal font
 func mustLoadFont() *graphbox.TTFFont {
 	font, err := loadInternalFont(dejaVuSansFont)
 	if err != nil {
 		panic(errors.New("Could not load internal font: " + dejaVuSansFont))
 	}
+	if err := addFontVariants(font); err != nil {
+		panic(errors.New("Could not load font variants: " + err.Error()))
+	}
 
 	return font
 }
@@ -38,6 +50,22 @@ type actorInfo struct {
 
 	// Actor column
 	Col int
+
+	// The open activation bars, from outermost to innermost
+	Activations []*graphbox.ActivationBar
+
+	// The actor's lifeline.  Nil if the actor has no lifeline or has not been placed yet.
+	LifeLine *graphbox.LifeLine
+
+	// True if the header has been placed
+	HeaderPlaced bool
+
+	// True if the actor is used by an action or destroyed somewhere within the diagram
+	HasAction  bool
+	HasDestroy bool
+
+	// True if the actor has been destroyed
+	Destroyed bool
 }
 
 type graphicBuilder struct {
@@ -46,10 +74,44 @@ type graphicBuilder struct {
 	Style   *DiagramStyles
 
 	actorInfos []actorInfo
+
+	// The last action placed and its activity line.  Used by activations which
+	// apply to the item before them.
+	lastAction     *Action
+	lastActionLine *graphbox.ActivityLine
+
+	// The current automatic numbering settings and the next number to use.  Nil if
+	// numbering is turned off.
+	autoNumber    *AutoNumber
+	nextSeqNumber int
+	seqNumberStep int
+	seqNumberUsed bool
+
+	// The boxes drawn behind groups of actors
+	groupBoxes map[*ActorGroup]*graphbox.GroupBox
+
+	// The rows actions have been placed in, for timing constraints
+	actionRows map[*Action]int
+
+	// The placed dimension lines of timing constraints and the rows they span
+	dimensionLines []placedDimensionLine
+}
+
+// A dimension line and the rows it spans
+type placedDimensionLine struct {
+	line           *graphbox.DimensionLine
+	fromRow, toRow int
+}
+
+// An item used as the header of an actor
+type actorHeader interface {
+	graphbox.GraphboxItem
+	LineEnds() graphbox.LineEnds
+	BoundingRect() graphbox.Rect
 }
 
 func newGraphicBuilder(d *Diagram, style *DiagramStyles) (*graphicBuilder, error) {
-	return &graphicBuilder{d, nil, style, nil}, nil
+	return &graphicBuilder{Diagram: d, Style: style, actionRows: make(map[*Action]int)}, nil
 }
 
 func (gb *graphicBuilder) buildGraphic() *graphbox.Graphic {
@@ -59,6 +121,7 @@ func (gb *graphicBuilder) buildGraphic() *graphbox.Graphic {
 	gb.Graphic.Margin = gb.Style.Margin
 	gb.Graphic.ShowGrid = false
 
+	gb.addActorGroups()
 	gb.addActors()
 
 	if len(gb.Diagram.Items) == 0 {
@@ -68,6 +131,9 @@ func (gb *graphicBuilder) buildGraphic() *graphbox.Graphic {
 		gb.putItemsInSlice(&row, 0, gb.Diagram.Items)
 	}
 
+	gb.closeActivations()
+	gb.layoutActorGroups()
+
 	// Add a title
 	if gb.Diagram.Title != "" {
 		gb.Graphic.Put(0, 0, graphbox.NewTitle(cols, gb.Diagram.Title, gb.Style.Title))
@@ -79,11 +145,39 @@ func (gb *graphicBuilder) buildGraphic() *graphbox.Graphic {
 // Place items in a slice.  This will update the rows pointer
 func (gb *graphicBuilder) putItemsInSlice(row *int, depth int, items []SequenceItem) {
 	for _, item := range items {
+		// Activations and destroys apply to the previous item and do not take up a row
+		switch itemDetails := item.(type) {
+		case *Activation:
+			gb.putActivation(*row, itemDetails)
+			continue
+		case *Destroy:
+			gb.putDestroy(*row, itemDetails)
+			continue
+		case *AutoNumber:
+			gb.setAutoNumber(itemDetails)
+			continue
+		case *TimingConstraint:
+			gb.putTimingConstraint(itemDetails)
+			continue
+		case *Note:
+			// Notes on messages share the row of the message
+			if itemDetails.Align == OnMessageNoteAlignment {
+				gb.putMessageNote(*row-1, itemDetails)
+				continue
+			}
+		}
+
+		gb.lastAction, gb.lastActionLine = nil, nil
+
 		switch itemDetails := item.(type) {
 		case *Action:
 			gb.putAction(*row, itemDetails)
 		case *Note:
 			gb.putNote(*row, itemDetails)
+		case *Ref:
+			gb.putRef(*row, itemDetails)
+		case *StateInvariant:
+			gb.putStateInvariant(*row, itemDetails)
 		case *Divider:
 			gb.putDivider(*row, itemDetails)
 		case *Block:
@@ -115,6 +209,12 @@ func (gb *graphicBuilder) calcItemsInSlice(items []SequenceItem) int {
 				}
 			}
 			rows += 1
+		case *Activation, *Destroy, *AutoNumber, *TimingConstraint:
+			// Activations, destroys, autonumbers and timing constraints do not take up any rows
+		case *Note:
+			if itemDetails.Align != OnMessageNoteAlignment {
+				rows++
+			}
 		default:
 			rows++
 		}
@@ -124,7 +224,9 @@ func (gb *graphicBuilder) calcItemsInSlice(items []SequenceItem) int {
 
 // Places a note
 func (gb *graphicBuilder) putNote(row int, note *Note) {
-	if (note.Actor2 == nil) || (note.Actor1 == note.Actor2) {
+	if note.Align == AcrossNoteAlignment {
+		gb.putAcrossNote(row, note)
+	} else if (note.Actor2 == nil) || (note.Actor1 == note.Actor2) {
 		gb.putSingleActorNote(row, note.Actor1, note)
 	} else {
 		var leftActor, rightActor *Actor
@@ -158,48 +260,257 @@ func (gb *graphicBuilder) putSingleActorNote(row int, actor *Actor, note *Note)
 	}
 
 	col := gb.colOfActor(actor)
-	gb.Graphic.Put(row, col, graphbox.NewNoteBox(note.Message, gb.Style.NoteBox, pos))
+	gb.Graphic.Put(row, col, graphbox.NewNoteBox(note.Message, gb.noteStyle(note), pos))
 }
 
-// Places a note over a multiple actors.  This actually uses the divider graphics object
-// with the style adopted from the note style
+// Places a note over a multiple actors
 func (gb *graphicBuilder) putMultiActorOverNote(row int, leftActor *Actor, rightActor *Actor, note *Note) {
-	dividerBox := graphbox.DividerStyle{
-		Font:        gb.Style.NoteBox.Font,
-		FontSize:    gb.Style.NoteBox.FontSize,
-		Padding:     gb.Style.NoteBox.Padding,
-		Margin:      gb.Style.NoteBox.Margin,
-		TextPadding: graphbox.Point{0, 0},
-		Shape:       graphbox.DSFramedRect,
-		Overlap:     gb.Style.MultiNoteOverlap,
-	}
-
 	fromCol := gb.colOfActor(leftActor)
 	toCol := gb.colOfActor(rightActor)
 
-	// TODO: This was just to avoid bad styling of notes which reference 'left' and 'right'
-	// This needs to be fixed using proper styling, instead of this hack.
-	if fromCol == 0 {
-		fromCol = 1
+	gb.Graphic.Put(row, fromCol, graphbox.NewSpanningNoteBox(toCol, note.Message, gb.noteStyle(note)))
+}
+
+// Places a note spanning from the first actor to the last
+func (gb *graphicBuilder) putAcrossNote(row int, note *Note) {
+	if len(gb.Diagram.Actors) == 0 {
+		gb.Graphic.Put(row, 0, graphbox.NewSpanningNoteBox(gb.Graphic.Cols()-1, note.Message, gb.noteStyle(note)))
+		return
+	}
+
+	fromCol := gb.colOfActor(gb.Diagram.Actors[0])
+	toCol := gb.colOfActor(gb.Diagram.Actors[len(gb.Diagram.Actors)-1])
+	if fromCol == toCol {
+		gb.Graphic.Put(row, fromCol, graphbox.NewNoteBox(note.Message, gb.noteStyle(note), graphbox.CenterNotePos))
+	} else {
+		gb.Graphic.Put(row, fromCol, graphbox.NewSpanningNoteBox(toCol, note.Message, gb.noteStyle(note)))
+	}
+}
+
+// Places a note on the message of the last action.  The note is placed next to the arrow head,
+// on the row the action arrives at.
+func (gb *graphicBuilder) putMessageNote(row int, note *Note) {
+	if (gb.lastAction != note.Action) || (gb.lastActionLine == nil) {
+		return
+	}
+
+	style := gb.noteStyle(note)
+	fromCol := gb.lastActionLine.TC
+	if !note.Action.From.isEndpoint() {
+		fromCol = gb.colOfActor(note.Action.From)
+	}
+
+	gb.Graphic.Put(row+gb.lastActionLine.Delay, gb.lastActionLine.TC, graphbox.NewMessageNoteBox(gb.lastActionLine, fromCol, note.Message, style))
+}
+
+// Places a state invariant over the lifeline of its actor
+func (gb *graphicBuilder) putStateInvariant(row int, state *StateInvariant) {
+	style := gb.Style.StateBox
+	style.MaxTextWidth = gb.maxTextWidth(0)
+
+	col := gb.colOfActor(state.Actor)
+	gb.Graphic.Put(row, col, graphbox.NewNoteBox(state.Message, style, graphbox.CenterNotePos))
+}
+
+// Returns the style of a note
+func (gb *graphicBuilder) noteStyle(note *Note) graphbox.NoteBoxStyle {
+	style := gb.Style.NoteBox
+	style.Shape = graphboxNoteShapeMapping[note.Shape]
+	style.Overlap = gb.Style.MultiNoteOverlap
+	style.MaxTextWidth = gb.maxTextWidth(note.MaxTextWidth)
+	return style
+}
+
+// Returns the width at which message, note and block text is wrapped.  The width set on
+// the item itself is used if it is greater than zero.
+func (gb *graphicBuilder) maxTextWidth(itemWidth int) int {
+	if itemWidth > 0 {
+		return itemWidth
+	} else if gb.Diagram.MaxTextWidth > 0 {
+		return gb.Diagram.MaxTextWidth
 	}
-	if toCol == gb.Graphic.Cols()-1 {
-		toCol = gb.Graphic.Cols() - 2
+	return gb.Style.MaxTextWidth
+}
+
+// Places a reference frame
+func (gb *graphicBuilder) putRef(row int, ref *Ref) {
+	fromCol := gb.colOfActor(ref.Actor1)
+	toCol := gb.colOfActor(ref.Actor2)
+	if toCol < fromCol {
+		fromCol, toCol = toCol, fromCol
 	}
 
-	gb.Graphic.Put(row, fromCol, graphbox.NewDivider(toCol, note.Message, dividerBox))
+	gb.Graphic.Put(row, fromCol, graphbox.NewRefFrame(toCol, ref.Message, gb.Style.Ref))
 }
 
 // Places an action
 func (gb *graphicBuilder) putAction(row int, action *Action) {
-	fromCol := gb.colOfActor(action.From)
-	toCol := gb.colOfActor(action.To)
+	var fromCol, toCol int
+	circleEnd := graphbox.NoCircleEnd
+
+	// Found and lost messages are drawn from or to a circle next to the other actor
+	if action.From.isEndpoint() {
+		toCol = gb.colOfActor(action.To)
+		fromCol, circleEnd = toCol, graphbox.FoundCircleEnd
+	} else if action.To.isEndpoint() {
+		fromCol = gb.colOfActor(action.From)
+		toCol, circleEnd = fromCol, graphbox.LostCircleEnd
+	} else {
+		fromCol = gb.colOfActor(action.From)
+		toCol = gb.colOfActor(action.To)
+	}
 
 	style := gb.Style.ActivityLine
 
 	style.ArrowHead = gb.Style.ArrowHeads[action.Arrow.Head] //graphboxArrowHeadMapping[action.Arrow.Head]
 	style.ArrowStem = graphboxArrowStemMapping[action.Arrow.Stem]
+	style.MaxTextWidth = gb.maxTextWidth(action.MaxTextWidth)
 
-	gb.Graphic.Put(row, fromCol, graphbox.NewActivityLine(toCol, fromCol == toCol, action.Message, style))
+	message, seqNumber := gb.numberMessage(action.Message)
+
+	activityLine := graphbox.NewActivityLine(toCol, (fromCol == toCol) && (circleEnd == graphbox.NoCircleEnd), message, style)
+	activityLine.CircleEnd = circleEnd
+	activityLine.DoubleHeaded = action.Arrow.Bidirectional
+
+	// Delayed messages arrive on a later row, but no later than the last row of items
+	activityLine.Delay = action.Delay
+	if lastRow := gb.Graphic.Rows() - 2; row+activityLine.Delay > lastRow {
+		activityLine.Delay = maxInt(lastRow-row, 0)
+	}
+	if seqNumber != "" {
+		activityLine.SetSeqNumber(seqNumber)
+	}
+	activityLine.FromEnds = gb.activationLineEnds(action.From)
+	activityLine.ToEnds = gb.activationLineEnds(action.To)
+
+	// Created actors appear at the first action involving them, on the row the action
+	// leaves or arrives at
+	if ends, placed := gb.placeCreatedActor(row, action.From); placed {
+		activityLine.FromEnds = ends
+	}
+	if ends, placed := gb.placeCreatedActor(row+activityLine.Delay, action.To); placed {
+		activityLine.ToEnds = ends
+	}
+
+	gb.Graphic.Put(row, fromCol, activityLine)
+	gb.lastAction, gb.lastActionLine = action, activityLine
+	gb.actionRows[action] = row
+}
+
+// Places the dimension line of a timing constraint in the left margin.  Lines spanning
+// overlapping rows are placed further out so they do not overlap.
+func (gb *graphicBuilder) putTimingConstraint(constraint *TimingConstraint) {
+	fromRow, hasFrom := gb.actionRows[constraint.From]
+	toRow, hasTo := gb.actionRows[constraint.To]
+	if !hasFrom || !hasTo {
+		return
+	}
+	if toRow < fromRow {
+		fromRow, toRow = toRow, fromRow
+	}
+
+	line := graphbox.NewDimensionLine(toRow, constraint.Message, gb.Style.DimensionLine)
+	for _, placed := range gb.dimensionLines {
+		if (placed.fromRow <= toRow) && (fromRow <= placed.toRow) {
+			line.Offset = maxInt(line.Offset, placed.line.Offset+placed.line.Width())
+		}
+	}
+
+	gb.Graphic.Put(fromRow, 0, line)
+	gb.dimensionLines = append(gb.dimensionLines, placedDimensionLine{line, fromRow, toRow})
+}
+
+// Changes the automatic numbering of action messages
+func (gb *graphicBuilder) setAutoNumber(autoNumber *AutoNumber) {
+	if !autoNumber.Enabled {
+		gb.autoNumber = nil
+		return
+	}
+
+	if !autoNumber.Resume || !gb.seqNumberUsed {
+		gb.nextSeqNumber = autoNumber.Start
+		gb.seqNumberStep = autoNumber.Step
+	}
+	gb.autoNumber = autoNumber
+	gb.seqNumberUsed = true
+}
+
+// Returns the message of an action with the next sequence number prefixed to it.  If
+// the number is to be drawn in a circle, it is returned separately.
+func (gb *graphicBuilder) numberMessage(message string) (string, string) {
+	if gb.autoNumber == nil {
+		return message, ""
+	}
+
+	seqNumber := strconv.Itoa(gb.nextSeqNumber)
+	gb.nextSeqNumber += gb.seqNumberStep
+
+	if gb.autoNumber.Circle {
+		return message, seqNumber
+	} else if message == "" {
+		return seqNumber + ".", ""
+	} else {
+		return seqNumber + ". " + message, ""
+	}
+}
+
+// Places or closes an activation bar.  Activations apply to the item on the row before
+// this one.
+func (gb *graphicBuilder) putActivation(row int, activation *Activation) {
+	if activation.Actor.rank < 0 {
+		return
+	}
+
+	info := &gb.actorInfos[activation.Actor.rank]
+	barRow := maxInt(row-1, posObjectY+1)
+
+	if !activation.Activate {
+		if n := len(info.Activations); n > 0 {
+			info.Activations[n-1].TR = barRow
+			info.Activations = info.Activations[:n-1]
+		}
+
+		// A self-referencing return arrow goes back to the enclosing bar
+		if (gb.lastAction != nil) && (gb.lastAction.From == activation.Actor) && (gb.lastAction.To == activation.Actor) {
+			gb.lastActionLine.ToEnds = gb.activationLineEnds(activation.Actor)
+		}
+		return
+	}
+
+	style := gb.Style.Activation
+	style.Color = activation.Actor.Color
+
+	bar := graphbox.NewActivationBar(barRow, len(info.Activations), style)
+	info.Activations = append(info.Activations, bar)
+
+	// Attach the arrow which caused the activation to the new bar
+	if (gb.lastAction != nil) && (gb.lastAction.To == activation.Actor) {
+		gb.lastActionLine.ToEnds = gb.activationLineEnds(activation.Actor)
+		if gb.lastAction.From == gb.lastAction.To {
+			bar.TopOffset = gb.Style.ActivityLine.SelfRefHeight
+		}
+	}
+
+	gb.Graphic.Put(barRow, info.Col, bar)
+}
+
+// Closes any activation bars still open at the end of the diagram
+func (gb *graphicBuilder) closeActivations() {
+	lastRow := maxInt(gb.Graphic.Rows()-2, posObjectY+1)
+	for i := range gb.actorInfos {
+		for _, bar := range gb.actorInfos[i].Activations {
+			bar.TR = lastRow
+		}
+		gb.actorInfos[i].Activations = nil
+	}
+}
+
+// Returns where arrows attach to the actor's lifeline given the actor's open activations
+func (gb *graphicBuilder) activationLineEnds(actor *Actor) graphbox.LineEnds {
+	if actor.rank < 0 {
+		return graphbox.LineEnds{}
+	}
+	return gb.Style.Activation.LineEnds(len(gb.actorInfos[actor.rank].Activations))
 }
 
 // Places a divider
@@ -237,11 +548,17 @@ func getInnerRanksRecursive(subItems []SequenceItem) []int {
 	ranks := []int{}
 	for _, subItem := range subItems {
 		if action, isAction := subItem.(*Action); isAction {
-			if action.From.rank == action.To.rank {
+			if action.From.isEndpoint() {
+				ranks = append(ranks, action.To.rank-1, action.To.rank)
+			} else if action.To.isEndpoint() {
+				ranks = append(ranks, action.From.rank, action.From.rank+1)
+			} else if action.From.rank == action.To.rank {
 				ranks = append(ranks, action.From.rank, action.To.rank + 1)
 			} else {
 				ranks = append(ranks, action.From.rank, action.To.rank)
 			}
+		} else if ref, isRef := subItem.(*Ref); isRef {
+			ranks = append(ranks, ref.Actor1.rank, ref.Actor2.rank)
 		} else if block, isBlock := subItem.(*Block); isBlock {
 			for _, segment := range block.Segments {
 				ranks = append(ranks, getInnerRanksRecursive(segment.SubItems)...)
@@ -286,9 +603,8 @@ func (gb *graphicBuilder) putBlockSegmentsSequentially(row *int, depth int, acti
 
 	var startCol, endCol int
 	if shouldBeFullWidth {
-		_, cols := gb.calcRowsAndCols()
 		startCol = 0
-		endCol = cols - 1
+		endCol = gb.Graphic.Cols() - 1
 	} else {
 		startCol, endCol = math.MaxInt, math.MinInt
 
@@ -330,6 +646,18 @@ func (gb *graphicBuilder) putBlockSegmentsSequentially(row *int, depth int, acti
 			segPrefix = "opt"
 		case LoopSegmentType:
 			segPrefix = "loop"
+		case BreakSegmentType:
+			segPrefix = "break"
+		case CriticalSegmentType:
+			segPrefix = "critical"
+		case NegSegmentType:
+			segPrefix = "neg"
+		case AssertSegmentType:
+			segPrefix = "assert"
+		case IgnoreSegmentType:
+			segPrefix = "ignore {" + strings.Join(seg.MessageNames, ", ") + "}"
+		case ConsiderSegmentType:
+			segPrefix = "consider {" + strings.Join(seg.MessageNames, ", ") + "}"
 		case EmptySegmentType:
 			showPrefix = false
 		}
@@ -338,6 +666,8 @@ func (gb *graphicBuilder) putBlockSegmentsSequentially(row *int, depth int, acti
 			segPrefix = seg.Prefix
 		}
 
+		style.MaxTextWidth = gb.maxTextWidth(seg.MaxTextWidth)
+
 		block := graphbox.NewBlock(endRow, endCol, nestDepth, i == len(action.Segments)-1,
 			segPrefix, showPrefix, seg.Message, style)
 		gb.Graphic.Put(startRow, startCol, block)
@@ -385,62 +715,237 @@ func (gb *graphicBuilder) determineActorInfo() int {
 	return cols
 }
 
-// Add the object headers and footers
+// Add the object headers, footers and lifelines.  Headers of created actors are added when
+// they are first used and destroyed actors have no footers.
 func (gb *graphicBuilder) addActors() {
-	// TODO: Proper styling
 	bottomRow := gb.Graphic.Rows() - 1
-	for rank, actor := range gb.Diagram.Actors {
-		var actorBoxPos graphbox.ActorBoxPos
+	gb.scanActorLifetimes(gb.Diagram.Items)
+
+	for _, actor := range gb.Diagram.Actors {
+		info := &gb.actorInfos[actor.rank]
+		if !actor.Created || !info.HasAction {
+			gb.addActorHeader(posObjectY, actor)
+		}
+
+		if !gb.hasFooter(actor) {
+			continue
+		}
 
-		if rank == 0 {
-			actorBoxPos = graphbox.LeftActorBox
-		} else if rank == len(gb.Diagram.Actors)-1 {
-			actorBoxPos = graphbox.RightActorBox
+		if actor.InHeader {
+			gb.Graphic.Put(bottomRow, info.Col, gb.newActorBox(actor, graphbox.BottomActorBox))
 		} else {
-			actorBoxPos = graphbox.MiddleActorBox
+			// Use the TopActorBox as that performs the layout
+			gb.Graphic.Put(bottomRow, info.Col, gb.newActorBox(actor, graphbox.TopActorBox))
 		}
+	}
+}
 
-		col := gb.colOfActor(actor)
+// Returns true if the actor has a footer.  Actors drawn with icons only have footers if
+// they are one of the UML participant kinds.
+func (gb *graphicBuilder) hasFooter(actor *Actor) bool {
+	hasIconFooter := (actor.Icon == nil) || (actor.Kind != ParticipantActorKind)
+	return !gb.actorInfos[actor.rank].HasDestroy && hasIconFooter && actor.InFooter
+}
+
+// Adds the boxes of the actor groups.  These are added before the actors so that they are
+// drawn behind them.
+func (gb *graphicBuilder) addActorGroups() {
+	bottomRow := gb.Graphic.Rows() - 1
+	gb.groupBoxes = make(map[*ActorGroup]*graphbox.GroupBox)
 
-		if actor.Lifeline {
-			gb.Graphic.Put(posObjectY, col, &graphbox.LifeLine{
-				TR: bottomRow,
-				TC: col,
-				Style: graphbox.LifeLineStyle{
-					Color: actor.Color,
-				},
-			})
+	for _, group := range gb.Diagram.ActorGroups {
+		if len(group.Actors) == 0 {
+			continue
 		}
 
-		if actor.Icon != nil {
-			actorIconStyle := gb.Style.ActorIconBox
-			actorIconStyle.Color = actor.Color
-			actorIconStyle.TextColor = actor.TextColor
+		style := gb.Style.GroupBox
+		if group.Color != "" {
+			style.Color = group.Color
+		}
 
-			if actor.InHeader {
-				gb.Graphic.Put(posObjectY, col, graphbox.NewActorIconBox(actor.Label, actor.Icon.graphboxIcon(), actorIconStyle, actorBoxPos|graphbox.TopActorBox))
-			}
-		} else {
-			// Configure the style
-			actorStyle := gb.Style.ActorBox
-			actorStyle.Color = actor.Color
-			actorStyle.TextColor = actor.TextColor
-
-			if actor.InHeader {
-				gb.Graphic.Put(posObjectY, col, graphbox.NewActorBox(actor.Label, actorStyle, actorBoxPos|graphbox.TopActorBox))
-				if actor.InFooter {
-					gb.Graphic.Put(bottomRow, col, graphbox.NewActorBox(actor.Label, actorStyle, actorBoxPos|graphbox.BottomActorBox))
-				}
-			} else {
-				if actor.InFooter {
-					// Use the TopActorBox as that performs the layout
-					gb.Graphic.Put(bottomRow, col, graphbox.NewActorBox(actor.Label, actorStyle, actorBoxPos|graphbox.TopActorBox))
+		fromCol := gb.actorInfos[group.Actors[0].rank].Col
+		toCol := gb.actorInfos[group.Actors[len(group.Actors)-1].rank].Col
+
+		box := graphbox.NewGroupBox(bottomRow, toCol, group.Title, style)
+		gb.Graphic.Put(posObjectY, fromCol, box)
+		gb.groupBoxes[group] = box
+	}
+}
+
+// Sets the extents of the group boxes from the actors within them and adds their layouts.
+// This is done once all the actors are placed.
+func (gb *graphicBuilder) layoutActorGroups() {
+	// All boxes extend to the tallest header and footer so that their edges line up
+	top, bottom := 0, 0
+	for _, actor := range gb.Diagram.Actors {
+		rect := gb.newActorHeader(actor).BoundingRect()
+		top = maxInt(top, -rect.Y)
+		if gb.hasFooter(actor) {
+			bottom = maxInt(bottom, rect.Y+rect.H)
+		}
+	}
+
+	for _, group := range gb.Diagram.ActorGroups {
+		box, hasBox := gb.groupBoxes[group]
+		if !hasBox {
+			continue
+		}
+
+		firstActor, lastActor := group.Actors[0], group.Actors[len(group.Actors)-1]
+		firstRect := gb.newActorHeader(firstActor).BoundingRect()
+		lastRect := gb.newActorHeader(lastActor).BoundingRect()
+		box.Ends = graphbox.LineEnds{firstRect.X, lastRect.X + lastRect.W}
+		box.Top, box.Bottom = top, bottom
+		box.LeftGap = gb.groupBoxGap(firstActor.rank-1, true)
+		box.RightGap = gb.groupBoxGap(lastActor.rank+1, false)
+
+		gb.Graphic.Put(posObjectY, gb.actorInfos[firstActor.rank].Col, box.Layout())
+	}
+}
+
+// Returns the space to keep between a group box and the actor of the given rank next to it.
+// This includes the padding of the actor's own box if it is in another group.
+func (gb *graphicBuilder) groupBoxGap(rank int, isLeftOfBox bool) int {
+	if (rank < 0) || (rank >= len(gb.Diagram.Actors)) {
+		return 0
+	}
+
+	actor := gb.Diagram.Actors[rank]
+	rect := gb.newActorHeader(actor).BoundingRect()
+
+	gap := gb.Style.ActorBox.Margin.X
+	if isLeftOfBox {
+		gap += rect.X + rect.W
+	} else {
+		gap -= rect.X
+	}
+	if actor.Group != nil {
+		gap += gb.Style.GroupBox.Padding.X
+	}
+	return gap
+}
+
+// Determine which actors are used by actions and which are destroyed
+func (gb *graphicBuilder) scanActorLifetimes(items []SequenceItem) {
+	for _, item := range items {
+		switch itemDetails := item.(type) {
+		case *Action:
+			for _, actor := range []*Actor{itemDetails.From, itemDetails.To} {
+				if actor.rank >= 0 {
+					gb.actorInfos[actor.rank].HasAction = true
 				}
 			}
+		case *Destroy:
+			gb.actorInfos[itemDetails.Actor.rank].HasDestroy = true
+		case *Block:
+			for _, seg := range itemDetails.Segments {
+				gb.scanActorLifetimes(seg.SubItems)
+			}
 		}
 	}
 }
 
+// Adds the header and lifeline of an actor at a particular row.  Returns where arrows
+// attach to the header.
+func (gb *graphicBuilder) addActorHeader(row int, actor *Actor) graphbox.LineEnds {
+	// TODO: Proper styling
+	bottomRow := gb.Graphic.Rows() - 1
+	info := &gb.actorInfos[actor.rank]
+	col := info.Col
+
+	info.HeaderPlaced = true
+
+	if actor.Lifeline {
+		info.LifeLine = &graphbox.LifeLine{
+			TR: bottomRow,
+			TC: col,
+			Style: graphbox.LifeLineStyle{
+				Color: actor.Color,
+			},
+		}
+		gb.Graphic.Put(row, col, info.LifeLine)
+	}
+
+	if !actor.InHeader {
+		return graphbox.LineEnds{}
+	}
+
+	header := gb.newActorHeader(actor)
+	gb.Graphic.Put(row, col, header)
+	return header.LineEnds()
+}
+
+// Creates the header item of an actor
+func (gb *graphicBuilder) newActorHeader(actor *Actor) actorHeader {
+	return gb.newActorBox(actor, graphbox.TopActorBox)
+}
+
+// Creates the header or footer item of an actor
+func (gb *graphicBuilder) newActorBox(actor *Actor, vertPos graphbox.ActorBoxPos) actorHeader {
+	actorBoxPos := gb.actorBoxPos(actor)
+
+	if actor.Icon != nil {
+		actorIconStyle := gb.Style.ActorIconBox
+		actorIconStyle.Color = actor.Color
+		actorIconStyle.TextColor = actor.TextColor
+		actorIconStyle.Stereotype = actor.Stereotype
+
+		return graphbox.NewActorIconBox(actor.Label, actor.Icon.graphboxIcon(), actorIconStyle, actorBoxPos|vertPos)
+	} else {
+		// Configure the style
+		actorStyle := gb.Style.ActorBox
+		actorStyle.Color = actor.Color
+		actorStyle.TextColor = actor.TextColor
+		actorStyle.Stereotype = actor.Stereotype
+
+		return graphbox.NewActorBox(actor.Label, actorStyle, actorBoxPos|vertPos)
+	}
+}
+
+// Returns the horizontal position of the actor's boxes
+func (gb *graphicBuilder) actorBoxPos(actor *Actor) graphbox.ActorBoxPos {
+	if actor.rank == 0 {
+		return graphbox.LeftActorBox
+	} else if actor.rank == len(gb.Diagram.Actors)-1 {
+		return graphbox.RightActorBox
+	} else {
+		return graphbox.MiddleActorBox
+	}
+}
+
+// Adds the header of a created actor if it has not been placed yet.  Returns where arrows
+// attach to the header, and true if the header was added.
+func (gb *graphicBuilder) placeCreatedActor(row int, actor *Actor) (graphbox.LineEnds, bool) {
+	if (actor.rank < 0) || gb.actorInfos[actor.rank].HeaderPlaced {
+		return graphbox.LineEnds{}, false
+	}
+	return gb.addActorHeader(row, actor), true
+}
+
+// Ends the lifeline of a destroyed actor.  Like activations, this applies to the item on the row
+// before this one.
+func (gb *graphicBuilder) putDestroy(row int, destroy *Destroy) {
+	info := &gb.actorInfos[destroy.Actor.rank]
+	if info.Destroyed {
+		return
+	}
+
+	destroyRow := maxInt(row-1, posObjectY+1)
+	info.Destroyed = true
+
+	if info.LifeLine != nil {
+		info.LifeLine.TR = destroyRow
+	}
+	for _, bar := range info.Activations {
+		bar.TR = destroyRow
+	}
+	info.Activations = nil
+
+	style := gb.Style.DestroyMarker
+	style.Color = destroy.Actor.Color
+	gb.Graphic.Put(destroyRow, info.Col, &graphbox.DestroyMarker{style})
+}
+
 // Returns the column position of an actor
 func (gb *graphicBuilder) colOfActor(actor *Actor) int {
 	if actor == LeftOffsideActor {
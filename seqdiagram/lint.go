@@ -0,0 +1,395 @@
+// Checks diagrams for likely mistakes
+
+package seqdiagram
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lmika/goseq/seqdiagram/parse"
+)
+
+// A problem found by a lint rule
+type LintFinding struct {
+	// The name of the rule which found the problem
+	Rule string
+
+	Span    parse.Span
+	Message string
+}
+
+// A lint rule.  Rules either check the nodes of a file, which is used for problems the
+// diagram does not record, or the diagram built from them.
+type LintRule struct {
+	Name        string
+	Description string
+
+	checkNodes   func(nl *parse.NodeList, l *linter)
+	checkDiagram func(d *Diagram, l *linter)
+}
+
+// All the lint rules, in the order they are listed
+var LintRules = []*LintRule{
+	{"unused-participant", "Declared participants which never send or receive a message", nil, lintUnusedParticipants},
+	{"implicit-typo", "Participants created by referring to them, whose names are close to those of other participants", nil, lintImplicitTypos},
+	{"empty-block", "Blocks without any items", nil, lintEmptyBlocks},
+	{"empty-else", "Else segments without any items", nil, lintEmptyElseSegments},
+	{"unread-attribute", "Attributes and styles which are not read by anything", lintUnreadAttributes, nil},
+	{"duplicate-title", "Titles which replace an earlier title", lintDuplicateTitles, nil},
+	{"unknown-icon", "Participant icons which are not built in", lintUnknownIcons, nil},
+}
+
+// Returns the lint rule with a name, or nil if there is no such rule
+func LookupLintRule(name string) *LintRule {
+	for _, rule := range LintRules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+// The keywords of the block segment types, as used in lint messages
+var segmentKeywords = map[SegmentType]string{
+	AltSegmentType:              "alt",
+	ElseSegmentType:             "else",
+	ParSegmentType:              "par",
+	ParElseSegmentType:          "else",
+	OptSegmentType:              "opt",
+	LoopSegmentType:             "loop",
+	ConcurrentSegmentType:       "concurrent",
+	ConcurrentWhilstSegmentType: "whilst",
+	BreakSegmentType:            "break",
+	CriticalSegmentType:         "critical",
+	NegSegmentType:              "neg",
+	AssertSegmentType:           "assert",
+	IgnoreSegmentType:           "ignore",
+	ConsiderSegmentType:         "consider",
+	EmptySegmentType:            "block",
+}
+
+// Checks the nodes of a parsed file, and the diagram built from them, using a set of rules.
+// The findings are returned in the order they appear in the file.  If the diagram cannot be
+// built, the findings of the rules checking the nodes are returned along with the error.
+func Lint(nl *parse.NodeList, filename string, rules []*LintRule) ([]*LintFinding, error) {
+	nl, err := parse.ResolveIncludes(nl, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &linter{}
+	for _, rule := range rules {
+		if rule.checkNodes != nil {
+			l.rule = rule
+			rule.checkNodes(nl, l)
+		}
+	}
+
+	d := NewDiagram()
+	tb := newTreeBuilder(nl, filename)
+	tb.lint = true
+	err = tb.buildTree(d)
+	if err == nil {
+		for _, rule := range rules {
+			if rule.checkDiagram != nil {
+				l.rule = rule
+				rule.checkDiagram(d, l)
+			}
+		}
+	}
+
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		a, b := l.findings[i].Span, l.findings[j].Span
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		} else if a.Start.Line != b.Start.Line {
+			return a.Start.Line < b.Start.Line
+		}
+		return a.Start.Column < b.Start.Column
+	})
+	return l.findings, err
+}
+
+// Collects the findings of the rules
+type linter struct {
+	// The rule being checked
+	rule *LintRule
+
+	findings []*LintFinding
+}
+
+func (l *linter) report(span parse.Span, msg string) {
+	l.findings = append(l.findings, &LintFinding{l.rule.Name, span, msg})
+}
+
+func lintUnusedParticipants(d *Diagram, l *linter) {
+	used := make(map[*Actor]bool)
+	forEachItem(d.Items, func(item SequenceItem) {
+		if action, isAction := item.(*Action); isAction {
+			used[action.From] = true
+			used[action.To] = true
+		}
+	})
+
+	for _, actor := range d.Actors {
+		if (actor.Attributes != nil) && !used[actor] {
+			l.report(actor.Span, fmt.Sprintf("participant %s is declared but never sends or receives a message", actor.Name))
+		}
+	}
+}
+
+// Reports participants which are not declared and have a name close to another participant.
+// When neither participant is declared, the one referred to the least is reported.
+func lintImplicitTypos(d *Diagram, l *linter) {
+	refs := make(map[*Actor]int)
+	forEachItem(d.Items, func(item SequenceItem) {
+		for _, actor := range itemActors(item) {
+			refs[actor]++
+		}
+	})
+
+	for _, actor := range d.Actors {
+		if actor.Attributes != nil {
+			continue
+		}
+
+		for _, other := range d.Actors {
+			if other == actor {
+				continue
+			} else if (other.Attributes == nil) && ((refs[other] < refs[actor]) || ((refs[other] == refs[actor]) && (other.rank > actor.rank))) {
+				continue
+			}
+
+			if similarNames(actor.Name, other.Name) || similarNames(actor.Name, other.Label) {
+				l.report(actor.Span, fmt.Sprintf("participant %s is created implicitly; did you mean %s?", actor.Name, other.Name))
+				break
+			}
+		}
+	}
+}
+
+func lintEmptyBlocks(d *Diagram, l *linter) {
+	forEachItem(d.Items, func(item SequenceItem) {
+		if block, isBlock := item.(*Block); isBlock && isEmptyBlock(block) {
+			l.report(block.Span, segmentKeywords[block.Segments[0].Type]+" block has no items")
+		}
+	})
+}
+
+func lintEmptyElseSegments(d *Diagram, l *linter) {
+	forEachItem(d.Items, func(item SequenceItem) {
+		block, isBlock := item.(*Block)
+		if !isBlock || isEmptyBlock(block) {
+			return
+		}
+
+		for _, seg := range block.Segments[1:] {
+			if len(seg.SubItems) == 0 {
+				l.report(seg.Span, segmentKeywords[seg.Type]+" segment has no items")
+			}
+		}
+	})
+}
+
+// Reports attributes which are not in AttributeNames, and styles for declarations which
+// do not exist
+func lintUnreadAttributes(nl *parse.NodeList, l *linter) {
+	check := func(attrs *parse.AttributeList, styleIdent string) {
+		for ; attrs != nil; attrs = attrs.Tail {
+			if !containsString(AttributeNames[styleIdent], attrs.Head.Name) {
+				msg := fmt.Sprintf("%s attribute %s is never read", styleIdent, attrs.Head.Name)
+				if closest := closestName(attrs.Head.Name, AttributeNames[styleIdent]); closest != "" {
+					msg += "; did you mean " + closest + "?"
+				}
+				l.report(attrs.Head.Span, msg)
+			}
+		}
+	}
+
+	parse.Walk(nl, func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.StyleNode:
+			if _, hasStyle := AttributeNames[n.Name]; hasStyle {
+				check(n.Attributes, n.Name)
+			} else {
+				l.report(n.Span, fmt.Sprintf("style %s is not used by any declaration", n.Name))
+			}
+		case *parse.ActorNode:
+			check(n.Attributes, styleIdentifierParticipant)
+		case *parse.BoxNode:
+			check(n.Attributes, styleIdentifierBox)
+		case *parse.AutoNumberNode:
+			check(n.Attributes, styleIdentifierAutoNumber)
+		case *parse.NoteNode:
+			check(n.Attributes, styleIdentifierNote)
+		case *parse.BlockNode:
+			for segs := n.Segments; segs != nil; segs = segs.Tail {
+				check(segs.Head.AttributeList, styleIdentifierBlock)
+			}
+		}
+	})
+}
+
+// Reports titles after the first.  Only the last title is shown.
+func lintDuplicateTitles(nl *parse.NodeList, l *linter) {
+	var first *parse.TitleNode
+	parse.Walk(nl, func(node parse.Node) {
+		if title, isTitle := node.(*parse.TitleNode); isTitle {
+			if first == nil {
+				first = title
+			} else if first.Filename == title.Filename {
+				l.report(title.Span, fmt.Sprintf("title replaces the title on line %d", first.Start.Line))
+			} else {
+				l.report(title.Span, fmt.Sprintf("title replaces the title in %s on line %d", first.Filename, first.Start.Line))
+			}
+		}
+	})
+}
+
+// Reports icons of participants, and of the participant style, which are not built in
+func lintUnknownIcons(nl *parse.NodeList, l *linter) {
+	check := func(attrs *parse.AttributeList) {
+		for ; attrs != nil; attrs = attrs.Tail {
+			if attrs.Head.Name != "icon" || attrs.Head.Value == "none" {
+				continue
+			} else if _, err := LookupActorIcon(attrs.Head.Value); err == nil {
+				continue
+			}
+
+			iconNames := make([]string, 0, len(builtinIcons))
+			for name := range builtinIcons {
+				iconNames = append(iconNames, name)
+			}
+			sort.Strings(iconNames)
+
+			msg := "unknown icon " + attrs.Head.Value
+			if closest := closestName(attrs.Head.Value, iconNames); closest != "" {
+				msg += "; did you mean " + closest + "?"
+			}
+			l.report(attrs.Head.Span, msg)
+		}
+	}
+
+	parse.Walk(nl, func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.StyleNode:
+			if n.Name == styleIdentifierParticipant {
+				check(n.Attributes)
+			}
+		case *parse.ActorNode:
+			check(n.Attributes)
+		}
+	})
+}
+
+// Calls a function for each sequence item, including the items within blocks
+func forEachItem(items []SequenceItem, fn func(item SequenceItem)) {
+	for _, item := range items {
+		fn(item)
+
+		if block, isBlock := item.(*Block); isBlock {
+			for _, seg := range block.Segments {
+				forEachItem(seg.SubItems, fn)
+			}
+		}
+	}
+}
+
+// Returns the actors a sequence item refers to
+func itemActors(item SequenceItem) []*Actor {
+	var actors []*Actor
+	switch i := item.(type) {
+	case *Action:
+		actors = []*Actor{i.From, i.To}
+	case *Note:
+		actors = []*Actor{i.Actor1, i.Actor2}
+	case *Ref:
+		actors = []*Actor{i.Actor1, i.Actor2}
+	case *StateInvariant:
+		actors = []*Actor{i.Actor}
+	case *Activation:
+		actors = []*Actor{i.Actor}
+	case *Destroy:
+		actors = []*Actor{i.Actor}
+	}
+	return actors
+}
+
+func isEmptyBlock(block *Block) bool {
+	for _, seg := range block.Segments {
+		if len(seg.SubItems) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the name closest to a name which is similar to it, or blank if none are similar
+func closestName(name string, names []string) string {
+	closest, closestDistance := "", 0
+	for _, n := range names {
+		if distance := editDistance(strings.ToLower(name), strings.ToLower(n)); similarNames(name, n) && ((closest == "") || (distance < closestDistance)) {
+			closest, closestDistance = n, distance
+		}
+	}
+	return closest
+}
+
+// Returns true if two different names differ by case, or by few enough edits to be a typo.
+// Short names need to differ only by case, as most names are one edit away from others of
+// the same length.
+func similarNames(a string, b string) bool {
+	if a == b {
+		return false
+	}
+
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	maxEdits := 0
+	if length := minInt(len([]rune(a)), len([]rune(b))); length >= 8 {
+		maxEdits = 2
+	} else if length >= 4 {
+		maxEdits = 1
+	}
+	return editDistance(a, b) <= maxEdits
+}
+
+// Returns the number of single character insertions, deletions, substitutions and
+// transpositions needed to change one string to another
+func editDistance(a string, b string) int {
+	ar, br := []rune(a), []rune(b)
+
+	// dist[i][j] is the distance between ar[:i] and br[:j]
+	dist := make([][]int, len(ar)+1)
+	for i := range dist {
+		dist[i] = make([]int, len(br)+1)
+		dist[i][0] = i
+	}
+	for j := range dist[0] {
+		dist[0][j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+
+			dist[i][j] = minInt(minInt(dist[i-1][j]+1, dist[i][j-1]+1), dist[i-1][j-1]+cost)
+			if (i > 1) && (j > 1) && (ar[i-1] == br[j-2]) && (ar[i-2] == br[j-1]) {
+				dist[i][j] = minInt(dist[i][j], dist[i-2][j-2]+1)
+			}
+		}
+	}
+	return dist[len(ar)][len(br)]
+}
@@ -14,7 +14,12 @@ type Diagram struct {
 	ProcessingInstructions []*ProcessingInstruction
 	Title                  string
 	Actors                 []*Actor
+	ActorGroups            []*ActorGroup
 	Items                  []SequenceItem
+
+	// The width at which text is wrapped, as set by the "maxwidth" attribute of the
+	// diagram style.  Zero uses the width of the diagram style.
+	MaxTextWidth int
 }
 
 // Creates a new, empty diagram
@@ -30,6 +35,16 @@ func ParseDiagram(r io.Reader, filename string) (*Diagram, error) {
 		return nil, err
 	}
 
+	return BuildDiagram(nl, filename)
+}
+
+// Builds a diagram from the nodes of a parsed file, including any files it includes
+func BuildDiagram(nl *parse.NodeList, filename string) (*Diagram, error) {
+	nl, err := parse.ResolveIncludes(nl, filename)
+	if err != nil {
+		return nil, err
+	}
+
 	d := NewDiagram()
 	tb := newTreeBuilder(nl, filename)
 	err = tb.buildTree(d)
@@ -119,6 +134,12 @@ type Actor struct {
 	Name  string
 	Label string
 
+	// The kind of participant, such as a boundary or database
+	Kind ActorKind
+
+	// The stereotype shown above the label, such as "service".  Blank for none.
+	Stereotype string
+
 	Icon      ActorIcon
 	InHeader  bool
 	InFooter  bool
@@ -126,13 +147,61 @@ type Actor struct {
 	Color     string
 	TextColor string
 
+	// True if the actor is created part way through the diagram.  The header
+	// of created actors appears at the row of the first action involving them.
+	Created bool
+
+	// The group the actor belongs to.  Nil if the actor is not within a box.
+	Group *ActorGroup
+
+	// Where the actor is declared, or first referred to if it has no declaration
+	Span parse.Span
+
+	// The attributes of the declaration, including those from styles.  Nil if the actor
+	// has no declaration.
+	Attributes *AttributeSet
+
 	rank int
 }
 
+// The kinds of participants.  All kinds other than ParticipantActorKind are drawn as
+// an icon.
+type ActorKind int
+
+const (
+	ParticipantActorKind ActorKind = iota
+	BoundaryActorKind              = iota
+	ControlActorKind               = iota
+	EntityActorKind                = iota
+	DatabaseActorKind              = iota
+	CollectionsActorKind           = iota
+	QueueActorKind                 = iota
+)
+
+// A group of actors drawn within a titled box
+type ActorGroup struct {
+	Title string
+
+	// The background color of the box.  Blank to use the default.
+	Color string
+
+	Actors []*Actor
+}
+
 // Special actors
 var LeftOffsideActor *Actor = &Actor{rank: -1}
 var RightOffsideActor *Actor = &Actor{rank: -2}
 
+// The endpoints of found and lost messages.  These are drawn as a filled circle a short
+// distance from the lifeline of the participant at the other end of the message.
+var FoundActor *Actor = &Actor{rank: -3}
+var LostActor *Actor = &Actor{rank: -4}
+
+// Returns true if the actor is the endpoint of a found or lost message
+func (a *Actor) isEndpoint() bool {
+	return (a == FoundActor) || (a == LostActor)
+}
+
 // The supported arrow stems
 type ArrowStem int
 
@@ -156,6 +225,9 @@ const (
 type Arrow struct {
 	Stem ArrowStem
 	Head ArrowHead
+
+	// True if the arrow has heads at both ends
+	Bidirectional bool
 }
 
 // Note alignments
@@ -165,6 +237,18 @@ const (
 	LeftNoteAlignment  NoteAlignment = iota
 	RightNoteAlignment               = iota
 	OverNoteAlignment                = iota
+	AcrossNoteAlignment              = iota
+	OnMessageNoteAlignment           = iota
+)
+
+// The shape of a note
+type NoteShape int
+
+const (
+	RectNoteShape    NoteShape = iota
+	FoldedNoteShape            = iota
+	RoundedNoteShape           = iota
+	HexagonNoteShape           = iota
 )
 
 // A sequence item
@@ -173,14 +257,27 @@ type SequenceItem interface {
 
 // Defines a note
 type Note struct {
-	// The note's alignment and position
+	// The note's alignment and position.  Both actors are nil for notes across all actors
+	// and notes on messages.
 	Actor1 *Actor
 	Actor2 *Actor
 
 	Align NoteAlignment
 
+	// The action a note on a message is attached to
+	Action *Action
+
 	// The message
 	Message string
+
+	// The shape of the note frame
+	Shape NoteShape
+
+	// The width at which the message is wrapped.  Zero uses the width of the diagram.
+	MaxTextWidth int
+
+	// Where the note appears in the source
+	Span parse.Span
 }
 
 // Defines an action
@@ -196,6 +293,85 @@ type Action struct {
 
 	// The message
 	Message string
+
+	// The number of rows below the start of the action that the message arrives on.  Zero
+	// if the message arrives immediately.
+	Delay int
+
+	// The label used to refer to the action from timing constraints
+	Label string
+
+	// The width at which the message is wrapped.  Zero uses the width of the diagram.
+	MaxTextWidth int
+
+	// Where the action appears in the source.  For returns, this is the return statement.
+	Span parse.Span
+}
+
+// Defines a timing constraint between two actions, drawn as a dimension line in the margin
+// spanning the rows of both actions.  Timing constraints do not take up a row of their own.
+type TimingConstraint struct {
+	From *Action
+	To   *Action
+
+	// The constraint, such as "< 200ms"
+	Message string
+}
+
+// Defines a state invariant, a condition which holds for an actor at a point in the
+// sequence.  State invariants are drawn over the actor's lifeline.
+type StateInvariant struct {
+	// The actor the state applies to
+	Actor *Actor
+
+	// The state, such as "Authenticated"
+	Message string
+}
+
+// Defines the activation or deactivation of an actor.  Activations apply to the
+// item immediately before them and do not take up a row of their own.  They can
+// be nested to show recursive calls.
+type Activation struct {
+	// The actor being activated or deactivated
+	Actor *Actor
+
+	// True to activate the actor, false to deactivate it
+	Activate bool
+}
+
+// Defines the destruction of an actor.  The actor's lifeline ends at the item
+// immediately before this one.  Like activations, destroys do not take up a row.
+type Destroy struct {
+	// The actor being destroyed
+	Actor *Actor
+}
+
+// Turns automatic numbering of action messages on or off.  Like activations,
+// this does not take up a row.
+type AutoNumber struct {
+	// False if numbering is to be turned off
+	Enabled bool
+
+	// True to continue from the last number used instead of restarting from Start
+	Resume bool
+
+	// The first number and the amount to increment it by for each action
+	Start int
+	Step  int
+
+	// If true, the number is drawn in a circle at the start of the arrow instead of
+	// prefixed to the message.
+	Circle bool
+}
+
+// Defines a reference to another interaction.  This is drawn as a frame over the actors.
+type Ref struct {
+	// The actors the reference spans
+	Actor1 *Actor
+	Actor2 *Actor
+
+	// The message
+	Message string
 }
 
 type DividerType int
@@ -214,12 +390,18 @@ type Divider struct {
 
 	// The divider type
 	Type DividerType
+
+	// Where the divider appears in the source
+	Span parse.Span
 }
 
 // A framed block of sequence items.  Each block can have one or more segments,
 // which will appear one after the other.
 type Block struct {
 	Segments []*BlockSegment
+
+	// Where the block appears in the source
+	Span parse.Span
 }
 
 func (b *Block) ShouldBeFullWidth() bool {
@@ -274,6 +456,24 @@ const (
 	// of a concurrent block.
 	ConcurrentWhilstSegmentType
 
+	// The break segment
+	BreakSegmentType
+
+	// The critical segment
+	CriticalSegmentType
+
+	// The neg segment
+	NegSegmentType
+
+	// The assert segment
+	AssertSegmentType
+
+	// IgnoreSegmentType is for "ignore" segments.  The ignored messages are listed in MessageNames.
+	IgnoreSegmentType
+
+	// ConsiderSegmentType is for "consider" segments.  The considered messages are listed in MessageNames.
+	ConsiderSegmentType
+
 	EmptySegmentType
 )
 
@@ -284,6 +484,15 @@ type BlockSegment struct {
 	Message  string
 	FullWidth bool
 	SubItems []SequenceItem
+
+	// The message names of ignore and consider segments
+	MessageNames []string
+
+	// The width at which the segment message is wrapped.  Zero uses the width of the diagram.
+	MaxTextWidth int
+
+	// Where the segment starts in the source.  This spans the segment's heading only.
+	Span parse.Span
 }
 
 // Returns the number of nested blocks
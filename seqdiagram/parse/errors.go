@@ -0,0 +1,110 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An error found while parsing a file
+type Error struct {
+	Filename string
+
+	// The position of the error.  The column is zero if it is not known.
+	Line   int
+	Column int
+
+	// A description of the error
+	Message string
+
+	// The tokens which were expected and the token which was found instead.  These are only
+	// set for syntax errors.
+	Expected []string
+	Found    string
+}
+
+func (e *Error) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", e.Filename, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Filename, e.Line, e.Column, e.Message)
+}
+
+// All the errors found while parsing a file, in the order they appear in the file
+type ErrorList []*Error
+
+func (el ErrorList) Error() string {
+	msgs := make([]string, len(el))
+	for i, err := range el {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// The names of tokens as they are shown in error messages.  Keyword tokens not in this
+// list are shown as the keyword itself.
+var tokenDisplayNames = map[string]string{
+	"$end":           "end of file",
+	"IDENT":          "identifier",
+	"STRING":         "string",
+	"MESSAGE":        "message",
+	"INT":            "number",
+	"DASH":           "'-'",
+	"DOUBLEDASH":     "'--'",
+	"DOT":            "'.'",
+	"EQUAL":          "'='",
+	"COMMA":          "','",
+	"PLUS":           "'+'",
+	"ANGR":           "'>'",
+	"DOUBLEANGR":     "'>>'",
+	"BACKSLASHANGR":  "'\\>'",
+	"SLASHANGR":      "'/>'",
+	"ANGL":           "'<'",
+	"PARL":           "'('",
+	"PARR":           "')'",
+	"BRACEL":         "'{'",
+	"BRACER":         "'}'",
+	"FOUND_ENDPOINT": "'[*]'",
+	"LOST_ENDPOINT":  "'[x]'",
+}
+
+// Returns the name of a token as shown in error messages
+func tokenDisplayName(name string) string {
+	if displayName, hasDisplayName := tokenDisplayNames[name]; hasDisplayName {
+		return displayName
+	} else if strings.HasPrefix(name, "K_") {
+		return "'" + strings.ToLower(strings.TrimPrefix(name, "K_")) + "'"
+	}
+	return name
+}
+
+// Converts a syntax error message produced by the parser, such as "syntax error: unexpected
+// IDENT, expecting MESSAGE or DOT", into the tokens which were expected and the token found.
+func parseSyntaxError(msg string) (expected []string, found string) {
+	msg = strings.TrimPrefix(strings.TrimPrefix(msg, "syntax error"), ": ")
+	if !strings.HasPrefix(msg, "unexpected ") {
+		return nil, ""
+	}
+
+	msg = strings.TrimPrefix(msg, "unexpected ")
+	parts := strings.SplitN(msg, ", expecting ", 2)
+	found = tokenDisplayName(parts[0])
+	if len(parts) == 2 {
+		for _, tok := range strings.Split(parts[1], " or ") {
+			expected = append(expected, tokenDisplayName(tok))
+		}
+	}
+	return expected, found
+}
+
+// Joins a list of alternatives, such as "a, b or c"
+func joinAlternatives(alts []string) string {
+	if len(alts) <= 1 {
+		return strings.Join(alts, "")
+	}
+	return strings.Join(alts[:len(alts)-1], ", ") + " or " + alts[len(alts)-1]
+}
+
+func init() {
+	// Have the parser report the unexpected and expected tokens of syntax errors
+	yyErrorVerbose = true
+}
@@ -0,0 +1,449 @@
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The indentation of each level of nested blocks
+const formatIndent = "    "
+
+var formatArrowStems = map[ArrowStemType]string{
+	SOLID_ARROW_STEM:  "-",
+	DASHED_ARROW_STEM: "--",
+	THICK_ARROW_STEM:  "=",
+}
+
+var formatArrowHeads = map[ArrowHeadType]string{
+	SOLID_ARROW_HEAD:        ">",
+	OPEN_ARROW_HEAD:         ">>",
+	BARBED_ARROW_HEAD:       "\\>",
+	LOWER_BARBED_ARROW_HEAD: "/>",
+}
+
+var formatNotePlaces = map[NoteAlignment]string{
+	LEFT_NOTE_ALIGNMENT:  "left of",
+	RIGHT_NOTE_ALIGNMENT: "right of",
+	OVER_NOTE_ALIGNMENT:  "over",
+}
+
+var formatGapTypes = map[GapType]string{
+	SPACER_GAP: "spacer",
+	EMPTY_GAP:  "gap",
+	LINE_GAP:   "line",
+	FRAME_GAP:  "frame",
+}
+
+var formatPseudoActors = map[PseudoActorRef]string{
+	"left":  "left",
+	"right": "right",
+	"found": "[*]",
+	"lost":  "[x]",
+}
+
+// The keywords starting the first segment of a block
+var formatFirstSegments = map[SegmentType]string{
+	ALT_SEGMENT:        "alt",
+	PAR_SEGMENT:        "par",
+	OPT_SEGMENT:        "opt",
+	LOOP_SEGMENT:       "loop",
+	CONCURRENT_SEGMENT: "concurrent",
+	BREAK_SEGMENT:      "break",
+	CRITICAL_SEGMENT:   "critical",
+	NEG_SEGMENT:        "neg",
+	ASSERT_SEGMENT:     "assert",
+	IGNORE_SEGMENT:     "ignore",
+	CONSIDER_SEGMENT:   "consider",
+	NONE_SEGMENT:       "block",
+}
+
+// The keywords starting the later segments of a block
+var formatLaterSegments = map[SegmentType]string{
+	ALT_SEGMENT:               "elsealt",
+	ALT_ELSE_SEGMENT:          "else",
+	PAR_SEGMENT:               "elsepar",
+	PAR_ELSE_SEGMENT:          "else",
+	CONCURRENT_WHILST_SEGMENT: "whilst",
+}
+
+// Formats a file as normalised source.  Each statement is written on its own line, indented
+// by the depth of the blocks it is in, with attributes in alphabetical order.  Comments and
+// processing instructions are kept in place, as are single blank lines between statements.
+func Format(nl *NodeList, comments []*Comment) []byte {
+	f := &formatter{buf: new(bytes.Buffer)}
+
+	// Processing instructions are placed at the start of the node list, so they are placed
+	// back into the file along with the comments
+	f.comments = append(f.comments, comments...)
+	for ; nl != nil; nl = nl.Tail {
+		if pi, isPi := nl.Head.(*ProcessInstructionNode); isPi {
+			text := "#!" + pi.Prefix
+			if pi.Value != "" {
+				text += " " + pi.Value
+			}
+			f.comments = append(f.comments, &Comment{pi.Span, text})
+		} else {
+			break
+		}
+	}
+	sort.SliceStable(f.comments, func(i, j int) bool {
+		return posBefore(f.comments[i].Start, f.comments[j].Start)
+	})
+
+	f.nodes(nl, 0)
+	f.commentsBefore(Pos{1 << 30, 0}, 0)
+	return f.buf.Bytes()
+}
+
+// Writes nodes as source
+type formatter struct {
+	buf *bytes.Buffer
+
+	// The comments which have not been written yet
+	comments []*Comment
+
+	// The source line the last line written ended at.  Zero at the start of the file.
+	lastLine int
+
+	// Set at the start and end of blocks, where blank lines are not kept
+	noBlankLine bool
+}
+
+func (f *formatter) nodes(nl *NodeList, depth int) {
+	for ; nl != nil; nl = nl.Tail {
+		f.node(nl.Head, depth)
+	}
+}
+
+func (f *formatter) node(node Node, depth int) {
+	span := node.NodeSpan()
+	f.commentsBefore(span.Start, depth)
+	f.startLine(span.Start.Line, depth)
+
+	switch n := node.(type) {
+	case *TitleNode:
+		f.buf.WriteString("title" + f.message(n.Title, n.TextBlock, depth))
+	case *StyleNode:
+		f.buf.WriteString("style " + n.Name + " (" + f.attrs(n.Attributes) + ")")
+	case *ActorNode:
+		f.actor(n, depth)
+	case *BoxNode:
+		heading := "box"
+		if n.Title != "" {
+			heading += " " + strconv.Quote(n.Title)
+		}
+		heading += f.maybeAttrs(n.Attributes)
+		f.block([]blockSection{{heading, Span{span.Filename, span.Start, span.Start}, n.Actors}}, span, depth)
+	case *IncludeNode:
+		f.buf.WriteString("include " + strconv.Quote(n.Path))
+	case *ActionNode:
+		if n.Label != "" {
+			f.buf.WriteString("{" + n.Label + "} ")
+		}
+		f.buf.WriteString(f.actorRef(n.From) + f.arrow(n))
+		f.buf.WriteString(f.actorRef(n.To) + f.message(n.Descr, n.TextBlock, depth))
+	case *TimingConstraintNode:
+		f.buf.WriteString("constraint " + n.FromLabel + ".." + n.ToLabel + f.message(n.Descr, n.TextBlock, depth))
+	case *StateNode:
+		f.buf.WriteString("state " + f.actorRef(n.Actor) + f.message(n.Descr, n.TextBlock, depth))
+	case *ReturnNode:
+		f.buf.WriteString("return")
+		if n.Descr != "" {
+			f.buf.WriteString(f.message(n.Descr, n.TextBlock, depth))
+		}
+	case *ActivationNode:
+		if n.Activate {
+			f.buf.WriteString("activate " + f.actorRef(n.Actor))
+		} else {
+			f.buf.WriteString("deactivate " + f.actorRef(n.Actor))
+		}
+	case *DestroyNode:
+		f.buf.WriteString("destroy " + f.actorRef(n.Actor))
+	case *AutoNumberNode:
+		f.buf.WriteString("autonumber")
+		if !n.Enabled {
+			f.buf.WriteString(" off")
+		} else if n.HasStart && (n.Step != 1) {
+			fmt.Fprintf(f.buf, " %d %d", n.Start, n.Step)
+		} else if n.HasStart {
+			fmt.Fprintf(f.buf, " %d", n.Start)
+		}
+		f.buf.WriteString(f.maybeAttrs(n.Attributes))
+	case *NoteNode:
+		f.note(n, span, depth)
+	case *RefNode:
+		f.buf.WriteString("ref over " + f.actorRef(n.Actor1))
+		if n.Actor2 != nil {
+			f.buf.WriteString(", " + f.actorRef(n.Actor2))
+		}
+		f.buf.WriteString(f.message(n.Descr, n.TextBlock, depth))
+	case *GapNode:
+		f.buf.WriteString("horizontal " + formatGapTypes[n.Type])
+		if n.Descr != "" {
+			f.buf.WriteString(f.message(n.Descr, n.TextBlock, depth))
+		}
+	case *BlockNode:
+		f.block(f.blockSections(n, depth), span, depth)
+	}
+
+	f.endLine(span.End.Line)
+}
+
+func (f *formatter) actor(an *ActorNode, depth int) {
+	if an.Create {
+		f.buf.WriteString("create ")
+	}
+	f.buf.WriteString("participant ")
+
+	if an.Alias {
+		f.buf.WriteString(quoteIdent(an.Descr) + " as " + an.Ident)
+	} else {
+		f.buf.WriteString(quoteIdent(an.Ident))
+	}
+	f.buf.WriteString(f.maybeAttrs(an.Attributes))
+
+	if an.HasDescr && !an.Alias {
+		f.buf.WriteString(f.message(an.Descr, an.TextBlock, depth))
+	}
+}
+
+func (f *formatter) note(nn *NoteNode, span Span, depth int) {
+	// The "rnote" and "hnote" keywords are parsed as a shape attribute placed at the keyword
+	keyword, attrs := "note", nn.Attributes
+	if (attrs != nil) && span.IsValid() && (attrs.Head.Span.Start == span.Start) {
+		keyword, attrs = map[string]string{"rounded": "rnote", "hexagon": "hnote"}[attrs.Head.Value], attrs.Tail
+	}
+	f.buf.WriteString(keyword + " ")
+
+	switch nn.Position {
+	case ACROSS_NOTE_ALIGNMENT:
+		f.buf.WriteString("across")
+	case ON_MESSAGE_NOTE_ALIGNMENT:
+		f.buf.WriteString("on message")
+	default:
+		f.buf.WriteString(formatNotePlaces[nn.Position] + " " + f.actorRef(nn.Actor1))
+		if nn.Actor2 != nil {
+			f.buf.WriteString(", " + f.actorRef(nn.Actor2))
+		}
+	}
+
+	f.buf.WriteString(f.maybeAttrs(attrs) + f.message(nn.Descr, nn.TextBlock, depth))
+}
+
+// A section of a block: a heading and the nodes following it
+type blockSection struct {
+	heading string
+	span    Span
+	nodes   *NodeList
+}
+
+func (f *formatter) blockSections(bn *BlockNode, depth int) []blockSection {
+	var sections []blockSection
+	for segs := bn.Segments; segs != nil; segs = segs.Tail {
+		seg := segs.Head
+
+		heading := formatFirstSegments[seg.Type]
+		if len(sections) > 0 {
+			heading = formatLaterSegments[seg.Type]
+		}
+		if len(seg.MessageNames) > 0 {
+			names := make([]string, len(seg.MessageNames))
+			for i, name := range seg.MessageNames {
+				names[i] = quoteIdent(name)
+			}
+			heading += " {" + strings.Join(names, ", ") + "}"
+		}
+		// Text blocks in headings are indented past the nodes of the block
+		heading += f.maybeAttrs(seg.AttributeList) + f.message(seg.Message, seg.TextBlock, depth+1)
+
+		sections = append(sections, blockSection{heading, seg.Span, seg.SubNodes})
+	}
+	return sections
+}
+
+// Writes the sections of a block followed by the "end" closing it.  The line of the first
+// heading has already been started.
+func (f *formatter) block(sections []blockSection, span Span, depth int) {
+	for i, section := range sections {
+		if i > 0 {
+			f.commentsBefore(section.span.Start, depth+1)
+			f.noBlankLine = true
+			f.startLine(section.span.Start.Line, depth)
+		}
+		f.buf.WriteString(section.heading)
+		f.endLine(section.span.End.Line)
+
+		f.noBlankLine = true
+		f.nodes(section.nodes, depth+1)
+	}
+
+	f.commentsBefore(Pos{span.End.Line, 0}, depth+1)
+	f.noBlankLine = true
+	f.startLine(span.End.Line, depth)
+	f.buf.WriteString("end")
+}
+
+// Writes the comments which appear before a position
+func (f *formatter) commentsBefore(pos Pos, depth int) {
+	for (len(f.comments) > 0) && posBefore(f.comments[0].Start, pos) {
+		comment := f.comments[0]
+		f.comments = f.comments[1:]
+
+		// Comments on the line of the previous statement are kept at the end of that line
+		if (f.lastLine > 0) && (comment.Start.Line == f.lastLine) && (f.buf.Len() > 0) {
+			f.buf.Truncate(f.buf.Len() - 1)
+			f.buf.WriteString(" " + comment.Text + "\n")
+			f.lastLine = comment.End.Line
+			continue
+		}
+
+		f.startLine(comment.Start.Line, depth)
+		f.buf.WriteString(comment.Text)
+		f.endLine(comment.End.Line)
+	}
+}
+
+// Starts a new line for something starting at a source line, keeping a blank line before
+// it if there is one in the source
+func (f *formatter) startLine(line int, depth int) {
+	if (f.lastLine > 0) && (line > f.lastLine+1) && !f.noBlankLine {
+		f.buf.WriteString("\n")
+	}
+	f.noBlankLine = false
+	f.buf.WriteString(strings.Repeat(formatIndent, depth))
+}
+
+// Ends the current line, which ended at a source line
+func (f *formatter) endLine(line int) {
+	f.buf.WriteString("\n")
+	f.lastLine = line
+}
+
+func (f *formatter) arrow(an *ActionNode) string {
+	arrow := ""
+	switch an.Arrow.Dir {
+	case FORWARD_ARROW:
+		arrow = formatArrowStems[an.Arrow.Stem] + formatArrowHeads[an.Arrow.Head]
+	case BACKWARD_ARROW:
+		arrow = "<" + formatArrowStems[an.Arrow.Stem]
+	case BIDIRECTIONAL_ARROW:
+		arrow = "<" + formatArrowStems[an.Arrow.Stem] + formatArrowHeads[an.Arrow.Head]
+	}
+
+	switch an.Activation {
+	case ACTIVATE_TARGET:
+		arrow += "+"
+	case DEACTIVATE_SOURCE:
+		arrow += "-"
+	}
+
+	if an.Delay > 0 {
+		arrow += fmt.Sprintf("(%d)", an.Delay)
+	}
+	return arrow
+}
+
+func (f *formatter) actorRef(ar ActorRef) string {
+	switch a := ar.(type) {
+	case NormalActorRef:
+		return string(a)
+	case QuotedActorRef:
+		return strconv.Quote(string(a))
+	case PseudoActorRef:
+		return formatPseudoActors[a]
+	default:
+		return ""
+	}
+}
+
+// Returns the attributes in alphabetical order, separated by commas.  Attributes with the
+// same name are kept in the same order, so the last one still applies.
+func (f *formatter) attrs(attrs *AttributeList) string {
+	var list []*Attribute
+	for ; attrs != nil; attrs = attrs.Tail {
+		list = append(list, attrs.Head)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	parts := make([]string, len(list))
+	for i, attr := range list {
+		parts[i] = attr.Name + "=" + strconv.Quote(attr.Value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Returns the attributes in parentheses with a leading space, or nothing if there are none
+func (f *formatter) maybeAttrs(attrs *AttributeList) string {
+	if attrs == nil {
+		return ""
+	}
+	return " (" + f.attrs(attrs) + ")"
+}
+
+// Returns a message, starting with the colon.  Messages with more than one line which were
+// written as text blocks are written as text blocks indented within the statement.  Other
+// messages are written on one line with escapes.
+func (f *formatter) message(msg string, textBlock bool, depth int) string {
+	if msg == "" {
+		return ":"
+	}
+
+	if textBlock && strings.Contains(msg, "\n") && canWriteTextBlock(msg) {
+		indent := strings.Repeat(formatIndent, depth+1)
+		text := new(strings.Builder)
+		text.WriteString(`: """` + "\n")
+		for _, line := range strings.Split(msg, "\n") {
+			if line != "" {
+				text.WriteString(indent + line)
+			}
+			text.WriteString("\n")
+		}
+		text.WriteString(indent + `"""`)
+		return text.String()
+	}
+
+	text := new(strings.Builder)
+	text.WriteString(": ")
+	runes := []rune(msg)
+	for i, r := range runes {
+		switch {
+		case r == '\n':
+			text.WriteString(`\n`)
+		case (r == '\\') && (i+1 < len(runes)) && strings.ContainsRune("*`~[]", runes[i+1]):
+			// Markup escapes are kept as they are
+			text.WriteRune(r)
+		case r == '\\':
+			text.WriteString(`\\`)
+		default:
+			text.WriteRune(r)
+		}
+	}
+	return text.String()
+}
+
+// Returns true if a message can be written as a text block.  Text blocks drop the space at
+// the end of each line, so messages with lines ending in spaces or tabs are written with escapes.
+func canWriteTextBlock(msg string) bool {
+	for _, line := range strings.Split(msg, "\n") {
+		if strings.HasSuffix(line, " ") || strings.HasSuffix(line, "\t") {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a name as an identifier if it can be written as one, otherwise as a string
+func quoteIdent(name string) string {
+	if IsIdent(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+// Returns true if the first position is before the second
+func posBefore(a Pos, b Pos) bool {
+	return (a.Line < b.Line) || ((a.Line == b.Line) && (a.Column < b.Column))
+}
@@ -0,0 +1,104 @@
+package parse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	src := strings.Join([]string{
+		"#!goseq out.svg",
+		"Title: Formatting",
+		"participant   Client # the client",
+		`participant Web as "Web Shop" (icon = "cylinder",color="red")`,
+		"",
+		"",
+		"Client  ->+  Web : Order",
+		"  alt (fullwidth = \"true\"): [in stock]",
+		"",
+		"      Web-->>Client:   Shipped\\nsoon",
+		"  // Nothing to do",
+		"  else:",
+		"      rnote over  Client : Wait",
+		"  end",
+		"{a} [*] <-- Client:",
+	}, "\n")
+
+	expected := strings.Join([]string{
+		"#!goseq out.svg",
+		"title: Formatting",
+		"participant Client # the client",
+		`participant "Web Shop" as Web (color="red", icon="cylinder")`,
+		"",
+		"Client->+Web: Order",
+		`alt (fullwidth="true"): [in stock]`,
+		"    Web-->>Client: Shipped\\nsoon",
+		"    // Nothing to do",
+		"else:",
+		"    rnote over Client: Wait",
+		"end",
+		"{a} [*]<--Client:",
+		"",
+	}, "\n")
+
+	nl, comments, err := ParseWithComments(strings.NewReader(src), "test.seq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if formatted := string(Format(nl, comments)); formatted != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, formatted)
+	}
+}
+
+func TestFormatIsStable(t *testing.T) {
+	files, _ := filepath.Glob("../../tests/*.seq")
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nl, comments, err := ParseWithComments(strings.NewReader(string(src)), file)
+		if err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+		formatted := Format(nl, comments)
+
+		nl, comments, err = ParseWithComments(strings.NewReader(string(formatted)), file)
+		if err != nil {
+			t.Errorf("%s: cannot parse formatted file: %s\n%s", file, err, formatted)
+			continue
+		}
+		if reformatted := Format(nl, comments); string(reformatted) != string(formatted) {
+			t.Errorf("%s: formatting again changes the file:\n%s\nto:\n%s", file, formatted, reformatted)
+		}
+	}
+}
+
+func FuzzFormat(f *testing.F) {
+	seeds, _ := filepath.Glob("../../tests/*.seq")
+	for _, seed := range seeds {
+		if src, err := os.ReadFile(seed); err == nil {
+			f.Add(string(src))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		nl, comments, err := ParseWithComments(strings.NewReader(src), "fuzz.seq")
+		if err != nil {
+			return
+		}
+		formatted := Format(nl, comments)
+
+		nl, comments, err = ParseWithComments(strings.NewReader(string(formatted)), "fuzz.seq")
+		if err != nil {
+			t.Fatalf("cannot parse formatted file: %s\n%s", err, formatted)
+		}
+		if reformatted := Format(nl, comments); string(reformatted) != string(formatted) {
+			t.Fatalf("formatting again changes the file:\n%s\nto:\n%s", formatted, reformatted)
+		}
+	})
+}
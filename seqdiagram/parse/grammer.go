@@ -9,12 +9,12 @@ import __yyfmt__ "fmt"
 
 import (
 	"bytes"
-	"errors"
-	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
 	"text/scanner"
+	"unicode"
 )
 
 var DualRunes = map[string]int{
@@ -24,29 +24,38 @@ var DualRunes = map[string]int{
 	"--": DOUBLEDASH,
 	"-":  DASH,
 	"=":  EQUAL,
+	"+":  PLUS,
 
+	"<":   ANGL,
 	">>":  DOUBLEANGR,
 	">":   ANGR,
 	"/>":  SLASHANGR,
 	"\\>": BACKSLASHANGR,
 }
 
-//line grammer.y:35
+//line grammer.y:37
 type yySymType struct {
 	yys          int
 	nodeList     *NodeList
 	node         Node
 	arrow        ArrowType
+	activationSh ActivationShorthand
 	arrowStem    ArrowStemType
 	arrowHead    ArrowHeadType
 	actorRef     ActorRef
+	actorNode    *ActorNode
+	span         Span
 	noteAlign    NoteAlignment
 	dividerType  GapType
 	blockSegList *BlockSegmentList
+	segmentType  SegmentType
 	attrList     *AttributeList
 	attr         *Attribute
+	strs         []string
 
-	sval string
+	sval      string
+	ival      int
+	textBlock bool
 }
 
 const K_TITLE = 57346
@@ -73,20 +82,50 @@ const K_PAR = 57366
 const K_ELSEPAR = 57367
 const K_CONCURRENT = 57368
 const K_WHILST = 57369
-const DASH = 57370
-const DOUBLEDASH = 57371
-const DOT = 57372
-const EQUAL = 57373
-const COMMA = 57374
-const ANGR = 57375
-const DOUBLEANGR = 57376
-const BACKSLASHANGR = 57377
-const SLASHANGR = 57378
-const PARL = 57379
-const PARR = 57380
-const STRING = 57381
-const MESSAGE = 57382
-const IDENT = 57383
+const K_ACTIVATE = 57370
+const K_DEACTIVATE = 57371
+const K_CREATE = 57372
+const K_DESTROY = 57373
+const K_AUTONUMBER = 57374
+const K_OFF = 57375
+const K_INCLUDE = 57376
+const K_REF = 57377
+const K_BREAK = 57378
+const K_CRITICAL = 57379
+const K_NEG = 57380
+const K_ASSERT = 57381
+const K_IGNORE = 57382
+const K_CONSIDER = 57383
+const K_BOX = 57384
+const K_ACROSS = 57385
+const K_RNOTE = 57386
+const K_HNOTE = 57387
+const K_CONSTRAINT = 57388
+const K_AS = 57389
+const K_STATE = 57390
+const K_RETURN = 57391
+const DASH = 57392
+const DOUBLEDASH = 57393
+const DOT = 57394
+const EQUAL = 57395
+const COMMA = 57396
+const PLUS = 57397
+const ANGR = 57398
+const DOUBLEANGR = 57399
+const BACKSLASHANGR = 57400
+const SLASHANGR = 57401
+const ANGL = 57402
+const PARL = 57403
+const PARR = 57404
+const BRACEL = 57405
+const BRACER = 57406
+const FOUND_ENDPOINT = 57407
+const LOST_ENDPOINT = 57408
+const SYNC = 57409
+const STRING = 57410
+const MESSAGE = 57411
+const IDENT = 57412
+const INT = 57413
 
 var yyToknames = [...]string{
 	"$end",
@@ -116,20 +155,50 @@ var yyToknames = [...]string{
 	"K_ELSEPAR",
 	"K_CONCURRENT",
 	"K_WHILST",
+	"K_ACTIVATE",
+	"K_DEACTIVATE",
+	"K_CREATE",
+	"K_DESTROY",
+	"K_AUTONUMBER",
+	"K_OFF",
+	"K_INCLUDE",
+	"K_REF",
+	"K_BREAK",
+	"K_CRITICAL",
+	"K_NEG",
+	"K_ASSERT",
+	"K_IGNORE",
+	"K_CONSIDER",
+	"K_BOX",
+	"K_ACROSS",
+	"K_RNOTE",
+	"K_HNOTE",
+	"K_CONSTRAINT",
+	"K_AS",
+	"K_STATE",
+	"K_RETURN",
 	"DASH",
 	"DOUBLEDASH",
 	"DOT",
 	"EQUAL",
 	"COMMA",
+	"PLUS",
 	"ANGR",
 	"DOUBLEANGR",
 	"BACKSLASHANGR",
 	"SLASHANGR",
+	"ANGL",
 	"PARL",
 	"PARR",
+	"BRACEL",
+	"BRACER",
+	"FOUND_ENDPOINT",
+	"LOST_ENDPOINT",
+	"SYNC",
 	"STRING",
 	"MESSAGE",
 	"IDENT",
+	"INT",
 }
 
 var yyStatenames = [...]string{}
@@ -138,46 +207,157 @@ const yyEofCode = 1
 const yyErrCode = 2
 const yyInitialStackSize = 16
 
-//line grammer.y:343
+//line grammer.y:660
 
 // Manages the lexer as well as the current diagram being parsed
 type parseState struct {
 	S     scanner.Scanner
-	err   error
+	errs  ErrorList
 	atEof bool
 	//diagram     *Diagram
-	procInstrs []string
+	procInstrs []procInstr
+	comments   []*Comment
 	nodeList   *NodeList
+
+	// The position of the most recently scanned token
+	tokLine int
+	tokCol  int
+
+	// The end of the most recently scanned token, if it is not the scanner position
+	tokEnd Pos
+
+	// The token most recently returned to the parser, and the kind of token returned
+	// before it
+	lastTok lexedToken
+	prevTok int
+
+	// Set after a syntax error, until the lexer has skipped to the next statement
+	recovering bool
+
+	// A token to return to the parser again after recovering from a syntax error
+	replay *lexedToken
+}
+
+// A processing instruction found in a comment
+type procInstr struct {
+	text string
+	span Span
+}
+
+// A token returned to the parser
+type lexedToken struct {
+	tok  int
+	lval yySymType
+	text string
+	line int
+	col  int
+
+	// True if the token is the first on its line
+	firstOnLine bool
+
+	// True if the token is being returned again after recovering from a syntax error
+	replayed bool
 }
 
 func newParseState(src io.Reader, filename string) *parseState {
 	ps := &parseState{}
 	ps.S.Init(src)
+	ps.S.Mode &^= scanner.SkipComments
 	ps.S.Position.Filename = filename
+	ps.S.Error = func(s *scanner.Scanner, msg string) {
+		ps.Error(msg)
+	}
 	//    ps.diagram = &Diagram{}
 
 	return ps
 }
 
 func (ps *parseState) Lex(lval *yySymType) int {
+	if ps.recovering {
+		ps.recovering = false
+		ps.skipErrorLine()
+		return SYNC
+	} else if ps.replay != nil {
+		ps.prevTok = ps.lastTok.tok
+		ps.lastTok, ps.replay = *ps.replay, nil
+		*lval = ps.lastTok.lval
+		return ps.lastTok.tok
+	}
+
+	prevLine := ps.lastTok.line
+	ps.tokEnd = Pos{}
+	tok := ps.lex(lval)
+	lval.span = ps.tokSpan()
+	ps.prevTok = ps.lastTok.tok
+	ps.lastTok = lexedToken{tok, *lval, ps.S.TokenText(), ps.tokLine, ps.tokCol, ps.tokLine > prevLine, false}
+	return tok
+}
+
+// Returns the span of the most recently scanned token
+func (ps *parseState) tokSpan() Span {
+	end := ps.tokEnd
+	if end.Line == 0 {
+		pos := ps.S.Pos()
+		end = Pos{pos.Line, pos.Column}
+	}
+	return Span{ps.S.Filename, Pos{ps.tokLine, ps.tokCol}, end}
+}
+
+// Returns the position of the scanner, which is the position of the next character
+func (ps *parseState) pos() Pos {
+	pos := ps.S.Pos()
+	return Pos{pos.Line, pos.Column}
+}
+
+// Skips the remaining tokens on the line of the token which caused a syntax error, so that
+// parsing resumes at the next statement.  If the token was the first on its line, it is taken
+// to be the start of the next statement and is kept, unless it has already been returned
+// again after an earlier error.
+func (ps *parseState) skipErrorLine() {
+	errTok := ps.lastTok
+	for (!errTok.firstOnLine || errTok.replayed) && (errTok.tok != 0) {
+		var lval yySymType
+		ps.Lex(&lval)
+		if (ps.lastTok.tok == 0) || (ps.lastTok.line > errTok.line) {
+			break
+		}
+	}
+
+	replay := ps.lastTok
+	replay.replayed = true
+	ps.replay = &replay
+}
+
+func (ps *parseState) lex(lval *yySymType) int {
 	if ps.atEof {
 		return 0
 	}
 	for {
 		tok := ps.S.Scan()
+		ps.tokLine, ps.tokCol = ps.S.Position.Line, ps.S.Position.Column
 		switch tok {
 		case scanner.EOF:
 			ps.atEof = true
 			return 0
 		case '#':
 			ps.scanComment()
+		case scanner.Comment:
+			ps.comments = append(ps.comments, &Comment{ps.tokSpan(), strings.TrimRight(ps.S.TokenText(), " \t\r")})
 		case ':':
 			return ps.scanMessage(lval)
 		case '(':
 			return PARL
 		case ')':
 			return PARR
-		case '-', '>', '*', '=', '/', '\\', '.', ',':
+		case '[':
+			if endpoint, isEndpoint := ps.scanEndpoint(); isEndpoint {
+				return endpoint
+			}
+		case '{':
+			return BRACEL
+		case '}':
+			return BRACER
+		case '-', '<', '>', '*', '=', '/', '\\', '.', ',', '+':
 			if res, isTok := ps.handleDoubleRune(tok); isTok {
 				return res
 			} else {
@@ -191,6 +371,14 @@ func (ps *parseState) Lex(lval *yySymType) int {
 			} else {
 				ps.Error("Invalid string: " + scanner.TokenString(tok) + ": " + err.Error())
 			}
+		case scanner.Int:
+			tokVal := ps.S.TokenText()
+			if res, err := strconv.Atoi(tokVal); err == nil {
+				lval.ival = res
+				return INT
+			} else {
+				ps.Error("Invalid number: " + tokVal + ": " + err.Error())
+			}
 		case scanner.Ident:
 			return ps.scanKeywordOrIdent(lval)
 		default:
@@ -220,66 +408,130 @@ func (ps *parseState) handleDoubleRune(firstRune rune) (int, bool) {
 	return 0, false
 }
 
+// The keywords, which are matched regardless of case.  Any other word is an identifier.
+var keywords = map[string]int{
+	"title":       K_TITLE,
+	"participant": K_PARTICIPANT,
+	"note":        K_NOTE,
+	"rnote":       K_RNOTE,
+	"hnote":       K_HNOTE,
+	"across":      K_ACROSS,
+	"constraint":  K_CONSTRAINT,
+	"state":       K_STATE,
+	"return":      K_RETURN,
+	"left":        K_LEFT,
+	"right":       K_RIGHT,
+	"over":        K_OVER,
+	"of":          K_OF,
+	"spacer":      K_SPACER,
+	"gap":         K_GAP,
+	"frame":       K_FRAME,
+	"block":       K_BLOCK,
+	"line":        K_LINE,
+	"style":       K_STYLE,
+	"horizontal":  K_HORIZONTAL,
+	"alt":         K_ALT,
+	"elsealt":     K_ELSEALT,
+	"par":         K_PAR,
+	"elsepar":     K_ELSEPAR,
+	"else":        K_ELSE,
+	"end":         K_END,
+	"loop":        K_LOOP,
+	"opt":         K_OPT,
+	"concurrent":  K_CONCURRENT,
+	"whilst":      K_WHILST,
+	"activate":    K_ACTIVATE,
+	"deactivate":  K_DEACTIVATE,
+	"create":      K_CREATE,
+	"destroy":     K_DESTROY,
+	"autonumber":  K_AUTONUMBER,
+	"off":         K_OFF,
+	"break":       K_BREAK,
+	"critical":    K_CRITICAL,
+	"neg":         K_NEG,
+	"assert":      K_ASSERT,
+	"ignore":      K_IGNORE,
+	"consider":    K_CONSIDER,
+	"box":         K_BOX,
+	"ref":         K_REF,
+	"include":     K_INCLUDE,
+}
+
+// Words which are only keywords within particular statements, where they are matched as
+// identifiers.  Elsewhere they can be used as identifiers.
+var contextKeywords = []string{
+	"as",
+	"on",
+}
+
 func (ps *parseState) scanKeywordOrIdent(lval *yySymType) int {
 	tokVal := ps.S.TokenText()
-	switch strings.ToLower(tokVal) {
-	case "title":
-		return K_TITLE
-	case "participant":
-		return K_PARTICIPANT
-	case "note":
-		return K_NOTE
-	case "left":
-		return K_LEFT
-	case "right":
-		return K_RIGHT
-	case "over":
-		return K_OVER
-	case "of":
-		return K_OF
-	case "spacer":
-		return K_SPACER
-	case "gap":
-		return K_GAP
-	case "frame":
-		return K_FRAME
-	case "block":
-		return K_BLOCK
-	case "line":
-		return K_LINE
-	case "style":
-		return K_STYLE
-	case "horizontal":
-		return K_HORIZONTAL
-	case "alt":
-		return K_ALT
-	case "elsealt":
-		return K_ELSEALT
-	case "par":
-		return K_PAR
-	case "elsepar":
-		return K_ELSEPAR
-	case "else":
-		return K_ELSE
-	case "end":
-		return K_END
-	case "loop":
-		return K_LOOP
-	case "opt":
-		return K_OPT
-	case "concurrent":
-		return K_CONCURRENT
-	case "whilst":
-		return K_WHILST
+	tok, isKeyword := keywords[strings.ToLower(tokVal)]
+	switch {
+	case isKeyword:
+		lval.sval = tokVal
+		return tok
+	case ps.isAliasKeyword(tokVal):
+		return K_AS
 	default:
 		lval.sval = tokVal
 		return IDENT
 	}
 }
 
-// Scans a message.  A message is all characters up to the new line
+// Returns true if a word is the "as" of an alias, which directly follows the name in a
+// participant declaration.  Anywhere else, "as" is an identifier.
+func (ps *parseState) isAliasKeyword(word string) bool {
+	return (strings.ToLower(word) == "as") && (ps.prevTok == K_PARTICIPANT) && (ps.lastTok.line == ps.tokLine)
+}
+
+// Returns the keywords in alphabetical order
+func Keywords() []string {
+	words := make([]string, 0, len(keywords)+len(contextKeywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	words = append(words, contextKeywords...)
+	sort.Strings(words)
+	return words
+}
+
+// Returns true if a name can be written as an identifier without quotes.  Keywords
+// cannot be used as identifiers.
+func IsIdent(name string) bool {
+	for i, r := range name {
+		if !((r == '_') || unicode.IsLetter(r) || ((i > 0) && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	_, isKeyword := keywords[strings.ToLower(name)]
+	return (name != "") && !isKeyword
+}
+
+// Scans a message.  A message is all characters up to the new line, or a text block
+// enclosed in triple quotes.
 func (ps *parseState) scanMessage(lval *yySymType) int {
 	buf := new(bytes.Buffer)
+	lval.textBlock = false
+
+	for (ps.S.Peek() == ' ') || (ps.S.Peek() == '\t') {
+		ps.NextRune()
+	}
+
+	// Check for the start of a text block.  Any quotes which turn out not to start one are
+	// part of the message.
+	quotes := 0
+	for (quotes < 3) && (ps.S.Peek() == '"') {
+		ps.NextRune()
+		quotes++
+	}
+	if quotes == 3 {
+		return ps.scanTextBlock(lval)
+	}
+	buf.WriteString(strings.Repeat(`"`, quotes))
+
+	// The message ends before the new line
+	ps.tokEnd = ps.pos()
 	r := ps.NextRune()
 	for (r != '\n') && (r != scanner.EOF) {
 		if r == '\\' {
@@ -289,12 +541,17 @@ func (ps *parseState) scanMessage(lval *yySymType) int {
 				buf.WriteRune('\n')
 			case '\\':
 				buf.WriteRune('\\')
+			case '*', '`', '~', '[', ']':
+				// Markup escapes are kept for the text box to interpret
+				buf.WriteRune('\\')
+				buf.WriteRune(nr)
 			default:
 				ps.Error("Invalid backslash escape: \\" + string(nr))
 			}
 		} else {
 			buf.WriteRune(r)
 		}
+		ps.tokEnd = ps.pos()
 		r = ps.NextRune()
 	}
 
@@ -302,26 +559,115 @@ func (ps *parseState) scanMessage(lval *yySymType) int {
 	return MESSAGE
 }
 
-// Scans a comment.  This ignores all characters up to the new line.
+// Scans a text block.  A text block is all characters up to the closing triple quotes, with
+// no escapes.  A blank first and last line are dropped and any indentation common to all
+// lines is removed, so the text can be indented along with the rest of the file.
+func (ps *parseState) scanTextBlock(lval *yySymType) int {
+	buf := new(bytes.Buffer)
+	quotes := 0
+
+	for quotes < 3 {
+		r := ps.NextRune()
+		if r == scanner.EOF {
+			ps.Error("Unterminated text block: expected closing \"\"\"")
+			return MESSAGE
+		} else if r == '"' {
+			quotes++
+		} else {
+			buf.WriteString(strings.Repeat(`"`, quotes))
+			buf.WriteRune(r)
+			quotes = 0
+		}
+	}
+	ps.tokEnd = ps.pos()
+
+	// Only whitespace can follow the end of the block
+	for r := ps.NextRune(); (r != '\n') && (r != scanner.EOF); r = ps.NextRune() {
+		if (r != ' ') && (r != '\t') && (r != '\r') {
+			ps.Error("Unexpected text after text block: " + string(r))
+			return MESSAGE
+		}
+	}
+
+	lval.sval = dedentTextBlock(buf.String())
+	lval.textBlock = true
+	return MESSAGE
+}
+
+// Removes the blank first and last lines and the common indentation from a text block
+func dedentTextBlock(text string) string {
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	if (len(lines) > 1) && (strings.TrimSpace(lines[0]) == "") {
+		lines = lines[1:]
+	}
+	if (len(lines) > 1) && (strings.TrimSpace(lines[len(lines)-1]) == "") {
+		lines = lines[:len(lines)-1]
+	}
+
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lineIndent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if (indent == -1) || (lineIndent < indent) {
+			indent = lineIndent
+		}
+	}
+
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if (indent > 0) && (len(line) >= indent) {
+			line = line[indent:]
+		}
+		lines[i] = line
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Scans the endpoint of a lost or found message.  These are either "[*]" or "[x]".
+func (ps *parseState) scanEndpoint() (int, bool) {
+	endpoint := 0
+
+	tok := ps.S.Scan()
+	if tok == '*' {
+		endpoint = FOUND_ENDPOINT
+	} else if (tok == scanner.Ident) && (strings.ToLower(ps.S.TokenText()) == "x") {
+		endpoint = LOST_ENDPOINT
+	}
+
+	if (endpoint == 0) || (ps.S.Scan() != ']') {
+		ps.Error("Invalid endpoint: expected [*] or [x]")
+		return 0, false
+	}
+	return endpoint, true
+}
+
+// Scans a comment.  This ignores all characters up to the new line.  Comments starting
+// with "#!" are processing instructions.
 func (ps *parseState) scanComment() {
-	var buf *bytes.Buffer
+	buf := new(bytes.Buffer)
 
+	end := ps.pos()
 	r := ps.NextRune()
-	if r == '!' {
-		// This starts a processor instruction
-		buf = new(bytes.Buffer)
+	isProcInstr := (r == '!')
+	if isProcInstr {
+		end = ps.pos()
 		r = ps.NextRune()
 	}
 
 	for (r != '\n') && (r != scanner.EOF) {
-		if buf != nil {
-			buf.WriteRune(r)
-		}
+		buf.WriteRune(r)
+		end = ps.pos()
 		r = ps.NextRune()
 	}
 
-	if buf != nil {
-		ps.procInstrs = append(ps.procInstrs, strings.TrimSpace(buf.String()))
+	span := Span{ps.S.Filename, Pos{ps.tokLine, ps.tokCol}, end}
+	if isProcInstr {
+		ps.procInstrs = append(ps.procInstrs, procInstr{strings.TrimSpace(buf.String()), span})
+	} else {
+		ps.comments = append(ps.comments, &Comment{span, "#" + strings.TrimRight(buf.String(), " \t\r")})
 	}
 }
 
@@ -338,128 +684,329 @@ func (ps *parseState) NextRune() rune {
 	return r
 }
 
+// Records an error.  Syntax errors reported by the parser are placed at the token which
+// caused them, and start the recovery to the next statement.
 func (ps *parseState) Error(err string) {
-	errMsg := fmt.Sprintf("%s:%d: %s", ps.S.Position.Filename, ps.S.Position.Line, err)
-	ps.err = errors.New(errMsg)
+	if strings.HasPrefix(err, "syntax error") {
+		ps.recovering = true
+
+		expected, found := parseSyntaxError(err)
+		switch ps.lastTok.tok {
+		case IDENT, STRING, INT:
+			found += " " + strconv.Quote(ps.lastTok.text)
+		}
+
+		msg := "Unexpected " + found
+		if len(expected) > 0 {
+			msg += ": expected " + joinAlternatives(expected)
+		}
+		ps.addError(&Error{ps.S.Filename, ps.lastTok.line, ps.lastTok.col, msg, expected, found})
+		return
+	}
+
+	// The position is invalidated when scanning messages rune by rune
+	line, col := ps.S.Position.Line, ps.S.Position.Column
+	if line == 0 {
+		pos := ps.S.Pos()
+		line, col = pos.Line, pos.Column
+	}
+
+	ps.addError(&Error{ps.S.Filename, line, col, err, nil, ""})
+}
+
+// Reports an identifier found where one of the expected words should be, like a syntax error
+func (ps *parseState) unexpectedIdent(span Span, ident string, expected ...string) {
+	found := "identifier " + strconv.Quote(ident)
+	msg := "Unexpected " + found + ": expected " + joinAlternatives(expected)
+	ps.addError(&Error{ps.S.Filename, span.Start.Line, span.Start.Column, msg, expected, found})
+}
+
+// Adds an error.  Only the first error on each line is kept, as any later errors on the
+// line are usually caused by it.
+func (ps *parseState) addError(err *Error) {
+	if (len(ps.errs) > 0) && (ps.errs[len(ps.errs)-1].Line == err.Line) {
+		return
+	}
+	ps.errs = append(ps.errs, err)
 }
 
+// Parses a file.  If the file contains errors, the error returned is an ErrorList holding
+// every error found and the node list holds the statements which could be parsed.
 func Parse(reader io.Reader, filename string) (*NodeList, error) {
+	nl, _, err := ParseWithComments(reader, filename)
+	return nl, err
+}
+
+// Parses a file along with its comments.  The comments are returned in the order they
+// appear in the file.  Processing instructions are not comments and are returned as nodes.
+func ParseWithComments(reader io.Reader, filename string) (*NodeList, []*Comment, error) {
 	ps := newParseState(reader, filename)
 	yyParse(ps)
 
 	// Add processing instructions to the start of the node list
 	for i := len(ps.procInstrs) - 1; i >= 0; i-- {
-		instrParts := strings.SplitN(ps.procInstrs[i], " ", 2)
-		name, value := strings.TrimSpace(instrParts[0]), strings.TrimSpace(instrParts[1])
-		ps.nodeList = &NodeList{&ProcessInstructionNode{name, value}, ps.nodeList}
+		instrParts := strings.SplitN(ps.procInstrs[i].text, " ", 2)
+		name, value := strings.TrimSpace(instrParts[0]), ""
+		if len(instrParts) > 1 {
+			value = strings.TrimSpace(instrParts[1])
+		}
+		ps.nodeList = &NodeList{&ProcessInstructionNode{ps.procInstrs[i].span, name, value}, ps.nodeList}
 	}
 
-	if ps.err != nil {
-		return nil, ps.err
+	if len(ps.errs) > 0 {
+		return ps.nodeList, ps.comments, ps.errs
 	} else {
-		return ps.nodeList, nil
+		return ps.nodeList, ps.comments, nil
 	}
 }
 
 //line yacctab:1
-var yyExca = [...]int8{
+var yyExca = [...]int16{
+	-1, 0,
+	1, 2,
+	-2, 0,
 	-1, 1,
 	1, -1,
 	-2, 0,
+	-1, 3,
+	1, 2,
+	19, 2,
+	20, 2,
+	21, 2,
+	25, 2,
+	27, 2,
+	-2, 0,
+	-1, 50,
+	5, 35,
+	21, 35,
+	30, 35,
+	-2, 92,
+	-1, 53,
+	50, 94,
+	51, 94,
+	53, 94,
+	60, 94,
+	-2, 66,
+	-1, 109,
+	20, 2,
+	21, 2,
+	25, 2,
+	-2, 0,
+	-1, 112,
+	21, 2,
+	27, 2,
+	-2, 0,
+	-1, 150,
+	19, 2,
+	20, 2,
+	21, 2,
+	-2, 0,
+	-1, 155,
+	21, 2,
+	-2, 0,
+	-1, 156,
+	21, 2,
+	-2, 0,
+	-1, 158,
+	21, 2,
+	-2, 0,
+	-1, 162,
+	21, 2,
+	-2, 0,
+	-1, 220,
+	21, 2,
+	-2, 0,
+	-1, 221,
+	20, 2,
+	21, 2,
+	25, 2,
+	-2, 0,
+	-1, 225,
+	19, 2,
+	20, 2,
+	21, 2,
+	-2, 0,
+	-1, 238,
+	21, 2,
+	-2, 0,
+	-1, 239,
+	19, 2,
+	20, 2,
+	21, 2,
+	-2, 0,
+	-1, 244,
+	21, 2,
+	-2, 0,
 }
 
 const yyPrivate = 57344
 
-const yyLast = 124
-
-var yyAct = [...]int8{
-	2, 97, 72, 88, 31, 34, 19, 74, 16, 18,
-	20, 17, 29, 30, 29, 30, 21, 83, 36, 113,
-	112, 27, 22, 110, 108, 82, 25, 24, 23, 104,
-	26, 103, 81, 80, 79, 77, 76, 71, 70, 57,
-	101, 35, 54, 32, 61, 28, 85, 28, 51, 67,
-	63, 64, 65, 66, 52, 75, 53, 86, 78, 39,
-	40, 87, 41, 94, 89, 99, 98, 69, 111, 90,
-	109, 107, 84, 55, 56, 106, 58, 91, 92, 105,
-	95, 102, 43, 44, 45, 60, 68, 33, 59, 100,
-	96, 47, 48, 49, 50, 73, 93, 46, 42, 62,
-	38, 37, 13, 12, 114, 115, 15, 14, 11, 116,
-	10, 9, 8, 117, 118, 7, 6, 5, 120, 119,
-	121, 4, 3, 1,
+const yyLast = 321
+
+var yyAct = [...]int16{
+	2, 31, 189, 214, 70, 177, 134, 163, 151, 87,
+	137, 6, 88, 79, 107, 82, 167, 54, 209, 232,
+	82, 106, 83, 153, 56, 57, 108, 83, 84, 85,
+	166, 119, 165, 84, 85, 256, 160, 199, 146, 126,
+	93, 98, 99, 100, 114, 115, 116, 80, 174, 218,
+	69, 82, 198, 81, 127, 69, 73, 76, 83, 108,
+	110, 111, 247, 113, 84, 85, 245, 117, 77, 118,
+	122, 244, 124, 243, 239, 238, 96, 233, 225, 221,
+	220, 58, 59, 74, 55, 82, 69, 129, 213, 211,
+	207, 183, 83, 75, 172, 171, 162, 144, 84, 85,
+	133, 130, 142, 97, 80, 158, 156, 155, 150, 149,
+	154, 128, 112, 157, 109, 108, 71, 120, 145, 94,
+	69, 78, 125, 161, 201, 143, 123, 90, 91, 234,
+	92, 186, 182, 178, 108, 202, 168, 89, 187, 108,
+	159, 138, 139, 140, 141, 180, 188, 169, 206, 173,
+	175, 185, 170, 179, 132, 176, 192, 193, 29, 196,
+	136, 90, 91, 200, 92, 135, 181, 131, 184, 195,
+	190, 148, 255, 216, 215, 191, 237, 231, 228, 208,
+	168, 168, 197, 30, 212, 204, 205, 226, 224, 223,
+	210, 222, 219, 203, 147, 121, 217, 102, 103, 104,
+	105, 227, 86, 72, 152, 34, 194, 101, 95, 164,
+	230, 49, 48, 26, 25, 24, 23, 235, 22, 21,
+	20, 240, 241, 229, 19, 18, 242, 17, 16, 13,
+	12, 15, 14, 11, 236, 10, 9, 8, 7, 248,
+	249, 246, 5, 4, 250, 252, 251, 3, 253, 1,
+	0, 0, 33, 254, 27, 29, 60, 28, 56, 57,
+	0, 0, 35, 0, 0, 0, 0, 41, 36, 0,
+	0, 0, 39, 38, 37, 0, 40, 0, 42, 43,
+	30, 44, 45, 0, 46, 47, 63, 64, 65, 66,
+	67, 68, 50, 0, 61, 62, 51, 0, 52, 53,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 32, 0, 58, 59, 0, 55, 0,
+	69,
 }
 
 var yyPact = [...]int16{
-	4, -1000, -1000, 4, -1000, -1000, -1000, -1000, -1000, -1000,
-	-1000, -1000, -1000, -1000, -1000, -1000, 3, 0, -23, 31,
-	74, 78, 19, 2, 19, 19, -1, 19, -1000, -1000,
-	-1000, -1000, -1000, 19, -1000, -1000, 19, 6, 17, -1000,
-	-1000, -1000, 6, 75, 56, -1000, -2, -1000, -1000, -1000,
-	-1000, -3, -1000, -34, 4, -4, -5, 4, -6, -1000,
-	-7, -8, -1000, -1000, -1000, -1000, -1000, -15, -1000, -1000,
-	-1000, 4, 8, 25, 30, 44, 4, 4, 36, 4,
-	-1000, -1000, -1000, 6, 46, -1000, -34, 1, 60, -9,
-	-11, 58, 54, 50, -16, 49, -17, 47, -20, -21,
-	-1000, -1000, -1000, 4, 4, -1000, -1000, -1000, 4, -1000,
-	-1000, -1000, 4, 4, -1000, 44, 46, -1000, 46, -1000,
-	-1000, -1000,
+	250, -1000, -1000, 250, -1000, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, -1000, 47, 51, -15,
+	197, 77, -30, 52, 33, 184, 73, 45, 73, 73,
+	43, 73, 16, 16, 16, -2, 49, 185, 73, 63,
+	54, -31, 16, 42, -1000, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, 73, -1000, -1000, -1000, -1000, -1000, -1000, 73,
+	120, 107, -1000, -1000, -1000, -1000, -15, 110, 85, 111,
+	-1000, -1000, -1000, 61, -1000, 16, 73, -32, 183, 160,
+	-1000, 40, -1000, -1000, -1000, -1000, 39, -1000, -47, 250,
+	38, 37, 250, 36, -1000, -1000, -1000, -1000, -35, -1000,
+	-1000, 16, 27, -38, 153, 73, 100, 26, -1000, -1000,
+	25, -20, 50, 73, 72, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, 85, 16, 78, 22, 73, -1000, -1000, -1000,
+	250, 69, 84, 93, 150, 250, 250, 142, 250, -1000,
+	73, -17, 250, 60, 81, -1000, -1000, 172, 153, 153,
+	96, -1000, -1000, -1000, -1000, -1000, 21, 16, -53, -1000,
+	77, 20, 16, -1000, 19, 154, -1000, -47, -19, 171,
+	11, 10, 170, 168, 167, 9, 166, -1000, -1000, 16,
+	157, 73, -38, -1000, -1000, 156, -51, -1000, 8, 67,
+	110, -1000, 73, -1000, 155, 6, 5, -1000, -1000, -1000,
+	250, 250, -1000, -1000, -1000, 250, -1000, 4, -1000, 2,
+	-1000, -1000, -3, -1000, -1000, 72, -7, -1000, 250, 250,
+	-1000, 150, 154, -1000, 250, -1000, 16, -1000, -1000, 154,
+	-1000, -1000, 151, -34, -1000, -1000, -1000,
 }
 
-var yyPgo = [...]int8{
-	0, 123, 0, 122, 121, 117, 116, 115, 112, 111,
-	110, 108, 107, 106, 103, 102, 101, 6, 100, 99,
-	98, 97, 1, 3, 96, 48, 2, 54, 95, 87,
+var yyPgo = [...]uint8{
+	0, 249, 0, 247, 243, 242, 11, 238, 237, 236,
+	235, 233, 232, 231, 230, 229, 228, 227, 225, 224,
+	220, 219, 218, 216, 215, 214, 213, 16, 212, 211,
+	7, 209, 9, 6, 5, 1, 13, 12, 10, 208,
+	207, 3, 2, 206, 21, 8, 14, 205, 204, 203,
+	17,
 }
 
 var yyR1 = [...]int8{
 	0, 1, 2, 2, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 4, 5, 29, 29,
-	25, 25, 27, 26, 26, 26, 28, 6, 6, 7,
-	8, 8, 17, 17, 17, 9, 9, 13, 10, 22,
-	22, 22, 11, 23, 23, 23, 14, 15, 12, 24,
-	24, 21, 21, 21, 21, 20, 20, 20, 16, 18,
-	18, 18, 19, 19, 19, 19,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 4, 5, 49,
+	49, 49, 49, 49, 49, 44, 44, 46, 45, 45,
+	45, 48, 6, 6, 6, 6, 36, 36, 36, 36,
+	36, 23, 23, 27, 27, 19, 18, 18, 18, 18,
+	17, 7, 7, 7, 24, 25, 26, 26, 33, 33,
+	33, 34, 34, 16, 16, 8, 8, 8, 8, 47,
+	47, 47, 20, 20, 35, 35, 35, 35, 35, 35,
+	50, 50, 50, 50, 50, 9, 9, 13, 10, 41,
+	41, 41, 11, 42, 42, 42, 14, 15, 21, 28,
+	28, 28, 28, 22, 29, 29, 30, 30, 31, 31,
+	12, 43, 43, 40, 40, 40, 40, 39, 39, 39,
+	32, 32, 32, 37, 37, 37, 38, 38, 38, 38,
 }
 
 var yyR2 = [...]int8{
 	0, 1, 0, 2, 1, 1, 1, 1, 1, 1,
-	1, 1, 1, 1, 1, 1, 2, 3, 1, 1,
-	0, 1, 3, 0, 1, 3, 3, 3, 4, 4,
-	4, 6, 1, 1, 1, 2, 3, 5, 6, 0,
-	3, 4, 5, 0, 3, 4, 5, 5, 5, 0,
-	4, 1, 1, 1, 1, 2, 2, 1, 2, 1,
-	1, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 1, 1, 2, 3, 1,
+	1, 1, 1, 1, 1, 0, 1, 3, 0, 1,
+	3, 3, 3, 4, 4, 5, 1, 1, 3, 3,
+	3, 4, 5, 0, 2, 2, 2, 3, 4, 2,
+	2, 6, 9, 2, 6, 3, 1, 2, 0, 1,
+	1, 0, 3, 2, 2, 5, 7, 4, 5, 1,
+	1, 1, 4, 6, 1, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 2, 3, 5, 6, 0,
+	3, 4, 5, 0, 3, 4, 5, 5, 5, 1,
+	1, 1, 1, 8, 1, 1, 1, 3, 1, 1,
+	5, 0, 4, 1, 1, 1, 1, 2, 2, 1,
+	2, 2, 3, 1, 1, 1, 1, 1, 1, 1,
 }
 
 var yyChk = [...]int16{
 	-1000, -1, -2, -3, -4, -5, -6, -7, -8, -9,
-	-10, -11, -14, -15, -12, -13, 4, 7, 5, -17,
-	6, 12, 18, 24, 23, 22, 26, 17, 41, 8,
-	9, -2, 40, -29, 5, 41, 41, -16, -18, 28,
-	29, 31, -20, 8, 9, 10, -21, 13, 14, 15,
-	16, -25, -27, 37, 40, -25, -25, 40, -25, -27,
-	-25, -17, -19, 33, 34, 35, 36, -17, 11, 11,
-	40, 40, -26, -28, 41, -2, 40, 40, -2, 40,
-	40, 40, 40, 32, -2, 38, 32, 31, -23, 20,
-	25, -2, -2, -24, 27, -2, -17, -22, 20, 19,
-	-26, 39, 21, 40, 40, 21, 21, 21, 40, 21,
-	40, 21, 40, 40, -2, -2, -2, -2, -2, -23,
-	-22, -22,
+	-10, -11, -14, -15, -12, -13, -16, -17, -18, -19,
+	-20, -21, -22, -23, -24, -25, -26, 4, 7, 5,
+	30, -35, 63, 2, -47, 12, 18, 24, 23, 22,
+	26, 17, 28, 29, 31, 32, 34, 35, -28, -29,
+	42, 46, 48, 49, -50, 68, 8, 9, 65, 66,
+	6, 44, 45, 36, 37, 38, 39, 40, 41, 70,
+	-2, 69, -49, 5, 32, 42, 6, 17, 70, -36,
+	-50, 68, 35, 42, 48, 49, 5, -32, -37, 60,
+	50, 51, 53, 70, 67, -39, 43, 70, 8, 9,
+	10, -40, 13, 14, 15, 16, -44, -46, 61, 69,
+	-44, -44, 69, -44, -35, -35, -35, -44, 71, 33,
+	68, 10, -44, 63, -44, 68, 70, -35, 69, -46,
+	-44, 47, 47, -36, -33, 55, 50, -38, 56, 57,
+	58, 59, -37, 64, -35, -44, 70, 11, 11, 69,
+	69, -45, -48, 70, -2, 69, 69, -2, 69, -44,
+	71, -35, 69, -30, -31, 70, 68, -27, -6, -44,
+	52, 69, 69, -50, 68, -50, -44, -34, 61, -38,
+	-35, -44, 54, 69, -44, -2, 62, 54, 53, -42,
+	20, 25, -2, -2, -43, 27, -2, -44, 69, 54,
+	-2, 64, 54, 21, -27, -27, 52, 69, -35, 71,
+	-32, 69, -35, 69, -41, 20, 19, -45, 68, 21,
+	69, 69, 21, 21, 21, 69, 21, -35, 21, -44,
+	-30, 21, 70, 69, 62, -33, -44, 21, 69, 69,
+	-2, -2, -2, 69, 69, 69, -34, 69, -2, -2,
+	-42, -41, -2, -35, -41, 21, 69,
 }
 
-var yyDef = [...]int8{
-	2, -2, 1, 2, 4, 5, 6, 7, 8, 9,
-	10, 11, 12, 13, 14, 15, 0, 0, 0, 0,
-	0, 0, 20, 0, 20, 20, 0, 20, 32, 33,
-	34, 3, 16, 0, 18, 19, 20, 0, 0, 59,
-	60, 61, 0, 0, 0, 57, 35, 51, 52, 53,
-	54, 0, 21, 23, 2, 0, 0, 2, 0, 17,
-	27, 0, 58, 62, 63, 64, 65, 0, 55, 56,
-	36, 2, 0, 24, 0, 43, 2, 2, 49, 2,
-	28, 29, 30, 0, 39, 22, 23, 0, 0, 0,
-	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	25, 26, 42, 2, 2, 46, 47, 48, 2, 37,
-	31, 38, 2, 2, 44, 43, 39, 40, 39, 45,
-	50, 41,
+var yyDef = [...]int16{
+	-2, -2, 1, -2, 4, 5, 6, 7, 8, 9,
+	10, 11, 12, 13, 14, 15, 16, 17, 18, 19,
+	20, 21, 22, 23, 24, 25, 26, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 35, 0, 35, 35,
+	0, 35, 0, 0, 0, 35, 0, 91, 35, 0,
+	-2, 0, 93, -2, 84, 85, 86, 87, 88, 89,
+	79, 80, 81, 109, 110, 111, 112, 114, 115, 90,
+	3, 27, 0, 29, 30, 31, 32, 33, 34, 35,
+	46, 47, 91, 92, 93, 94, 0, 68, 0, 0,
+	133, 134, 135, 0, 63, 0, 35, 0, 0, 0,
+	129, 95, 123, 124, 125, 126, 0, 36, 38, -2,
+	0, 0, -2, 0, 73, 74, 60, 56, 35, 59,
+	55, 0, 0, 0, 53, 35, 0, 0, 67, 28,
+	42, 0, 0, 35, 71, 69, 70, 130, 136, 137,
+	138, 139, 131, 0, 35, 0, 35, 127, 128, 96,
+	-2, 0, 39, 0, 103, -2, -2, 121, -2, 57,
+	35, 0, -2, 0, 116, 118, 119, 0, 53, 53,
+	0, 65, 43, 48, 50, 49, 44, 0, 0, 132,
+	0, 0, 0, 77, 0, 99, 37, 38, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 58, 82, 0,
+	0, 35, 0, 51, 54, 0, 0, 45, 0, 0,
+	68, 75, 35, 78, 0, 0, 0, 40, 41, 102,
+	-2, -2, 106, 107, 120, -2, 97, 0, 108, 0,
+	117, 52, 0, 61, 72, 71, 0, 98, -2, -2,
+	104, 103, 99, 83, -2, 64, 0, 76, 100, 99,
+	105, 122, 0, 0, 101, 113, 62,
 }
 
 var yyTok1 = [...]int8{
@@ -471,6 +1018,9 @@ var yyTok2 = [...]int8{
 	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
 	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
 	32, 33, 34, 35, 36, 37, 38, 39, 40, 41,
+	42, 43, 44, 45, 46, 47, 48, 49, 50, 51,
+	52, 53, 54, 55, 56, 57, 58, 59, 60, 61,
+	62, 63, 64, 65, 66, 67, 68, 69, 70, 71,
 }
 
 var yyTok3 = [...]int8{
@@ -816,319 +1366,721 @@ yydefault:
 
 	case 1:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:83
+//line grammer.y:115
 		{
 			yylex.(*parseState).nodeList = yyDollar[1].nodeList
 		}
 	case 2:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line grammer.y:90
+//line grammer.y:122
 		{
 			yyVAL.nodeList = nil
 		}
 	case 3:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line grammer.y:94
+//line grammer.y:126
 		{
-			yyVAL.nodeList = &NodeList{yyDollar[1].node, yyDollar[2].nodeList}
+			if yyDollar[1].node == nil {
+				yyVAL.nodeList = yyDollar[2].nodeList
+			} else {
+				yyVAL.nodeList = &NodeList{yyDollar[1].node, yyDollar[2].nodeList}
+			}
 		}
-	case 16:
+	case 27:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line grammer.y:116
+//line grammer.y:163
 		{
-			yyVAL.node = &TitleNode{yyDollar[2].sval}
+			yyVAL.node = &TitleNode{spanOf(yyDollar[1].span, yyDollar[2].span), yyDollar[2].sval, yyDollar[2].textBlock}
 		}
-	case 17:
+	case 28:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammer.y:123
+//line grammer.y:170
 		{
-			yyVAL.node = &StyleNode{yyDollar[2].sval, yyDollar[3].attrList}
+			yyVAL.node = &StyleNode{spanOf(yyDollar[1].span, yyDollar[3].span), yyDollar[2].sval, yyDollar[3].attrList}
 		}
-	case 18:
+	case 29:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:129
+//line grammer.y:176
 		{
 			yyVAL.sval = "participant"
 		}
-	case 19:
+	case 30:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:177
+		{
+			yyVAL.sval = "autonumber"
+		}
+	case 31:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:178
+		{
+			yyVAL.sval = "box"
+		}
+	case 32:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:179
+		{
+			yyVAL.sval = "note"
+		}
+	case 33:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:180
+		{
+			yyVAL.sval = "block"
+		}
+	case 34:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:130
+//line grammer.y:181
 		{
 			yyVAL.sval = yyDollar[1].sval
 		}
-	case 20:
+	case 35:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line grammer.y:135
+//line grammer.y:186
 		{
 			yyVAL.attrList = nil
+			yyVAL.span = Span{}
 		}
-	case 21:
+	case 36:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:139
+//line grammer.y:191
 		{
 			yyVAL.attrList = yyDollar[1].attrList
 		}
-	case 22:
+	case 37:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammer.y:146
+//line grammer.y:198
 		{
 			yyVAL.attrList = yyDollar[2].attrList
+			yyVAL.span = spanOf(yyDollar[1].span, yyDollar[3].span)
 		}
-	case 23:
+	case 38:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line grammer.y:153
+//line grammer.y:206
 		{
 			yyVAL.attrList = nil
 		}
-	case 24:
+	case 39:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:157
+//line grammer.y:210
 		{
 			yyVAL.attrList = &AttributeList{yyDollar[1].attr, nil}
 		}
-	case 25:
+	case 40:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammer.y:161
+//line grammer.y:214
 		{
 			yyVAL.attrList = &AttributeList{yyDollar[1].attr, yyDollar[3].attrList}
 		}
-	case 26:
+	case 41:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammer.y:168
+//line grammer.y:221
 		{
-			yyVAL.attr = &Attribute{yyDollar[1].sval, yyDollar[3].sval}
+			yyVAL.attr = &Attribute{spanOf(yyDollar[1].span, yyDollar[3].span), yyDollar[1].sval, yyDollar[3].sval}
 		}
-	case 27:
+	case 42:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammer.y:175
+//line grammer.y:228
 		{
-			yyVAL.node = &ActorNode{yyDollar[2].sval, false, "", yyDollar[3].attrList}
+			yyDollar[2].actorNode.Span, yyDollar[2].actorNode.Attributes = spanOf(yyDollar[1].span, yyDollar[2].span, yyDollar[3].span), yyDollar[3].attrList
+			yyVAL.node = yyDollar[2].actorNode
 		}
-	case 28:
+	case 43:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line grammer.y:179
+//line grammer.y:233
 		{
-			yyVAL.node = &ActorNode{yyDollar[2].sval, true, yyDollar[4].sval, yyDollar[3].attrList}
+			yyDollar[2].actorNode.Span, yyDollar[2].actorNode.HasDescr, yyDollar[2].actorNode.Descr, yyDollar[2].actorNode.Attributes = spanOf(yyDollar[1].span, yyDollar[4].span), true, yyDollar[4].sval, yyDollar[3].attrList
+			yyDollar[2].actorNode.TextBlock = yyDollar[4].textBlock
+			yyVAL.node = yyDollar[2].actorNode
 		}
-	case 29:
+	case 44:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line grammer.y:186
+//line grammer.y:239
 		{
-			yyVAL.node = &ActionNode{yyDollar[1].actorRef, yyDollar[3].actorRef, yyDollar[2].arrow, yyDollar[4].sval}
+			yyDollar[3].actorNode.Span, yyDollar[3].actorNode.Attributes, yyDollar[3].actorNode.Create = spanOf(yyDollar[1].span, yyDollar[3].span, yyDollar[4].span), yyDollar[4].attrList, true
+			yyVAL.node = yyDollar[3].actorNode
 		}
-	case 30:
+	case 45:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line grammer.y:244
+		{
+			yyDollar[3].actorNode.Span, yyDollar[3].actorNode.HasDescr, yyDollar[3].actorNode.Descr, yyDollar[3].actorNode.Attributes, yyDollar[3].actorNode.Create = spanOf(yyDollar[1].span, yyDollar[5].span), true, yyDollar[5].sval, yyDollar[4].attrList, true
+			yyDollar[3].actorNode.TextBlock = yyDollar[5].textBlock
+			yyVAL.node = yyDollar[3].actorNode
+		}
+	case 46:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:253
+		{
+			yyVAL.actorNode = &ActorNode{spanOf(yyDollar[1].span), yyDollar[1].sval, false, "", nil, false, yyDollar[1].span, false, false}
+		}
+	case 47:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:257
+		{
+			yyVAL.actorNode = &ActorNode{spanOf(yyDollar[1].span), yyDollar[1].sval, false, "", nil, false, yyDollar[1].span, false, false}
+		}
+	case 48:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammer.y:261
+		{
+			yyVAL.actorNode = &ActorNode{spanOf(yyDollar[1].span, yyDollar[3].span), yyDollar[3].sval, true, yyDollar[1].sval, nil, false, yyDollar[3].span, true, false}
+			yyVAL.span = yyVAL.actorNode.Span
+		}
+	case 49:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammer.y:266
+		{
+			yyVAL.actorNode = &ActorNode{spanOf(yyDollar[1].span, yyDollar[3].span), yyDollar[3].sval, true, yyDollar[1].sval, nil, false, yyDollar[3].span, true, false}
+			yyVAL.span = yyVAL.actorNode.Span
+		}
+	case 50:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammer.y:271
+		{
+			yyVAL.actorNode = &ActorNode{spanOf(yyDollar[1].span, yyDollar[3].span), yyDollar[1].sval, true, yyDollar[3].sval, nil, false, yyDollar[1].span, true, false}
+			yyVAL.span = yyVAL.actorNode.Span
+		}
+	case 51:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line grammer.y:193
+//line grammer.y:279
 		{
-			yyVAL.node = &NoteNode{yyDollar[3].actorRef, nil, yyDollar[2].noteAlign, yyDollar[4].sval}
+			yyVAL.node = &BoxNode{spanOf(yyDollar[1].span, yyDollar[4].span), "", yyDollar[2].attrList, yyDollar[3].nodeList}
 		}
-	case 31:
+	case 52:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line grammer.y:283
+		{
+			yyVAL.node = &BoxNode{spanOf(yyDollar[1].span, yyDollar[5].span), yyDollar[2].sval, yyDollar[3].attrList, yyDollar[4].nodeList}
+		}
+	case 53:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line grammer.y:290
+		{
+			yyVAL.nodeList = nil
+		}
+	case 54:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammer.y:294
+		{
+			yyVAL.nodeList = &NodeList{yyDollar[1].node, yyDollar[2].nodeList}
+		}
+	case 55:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammer.y:301
+		{
+			yyVAL.node = &IncludeNode{spanOf(yyDollar[1].span, yyDollar[2].span), yyDollar[2].sval}
+		}
+	case 56:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammer.y:308
+		{
+			yyVAL.node = &AutoNumberNode{spanOf(yyDollar[1].span, yyDollar[2].span), true, false, 1, 1, yyDollar[2].attrList}
+		}
+	case 57:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammer.y:312
+		{
+			yyVAL.node = &AutoNumberNode{spanOf(yyDollar[1].span, yyDollar[2].span, yyDollar[3].span), true, true, yyDollar[2].ival, 1, yyDollar[3].attrList}
+		}
+	case 58:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line grammer.y:316
+		{
+			yyVAL.node = &AutoNumberNode{spanOf(yyDollar[1].span, yyDollar[3].span, yyDollar[4].span), true, true, yyDollar[2].ival, yyDollar[3].ival, yyDollar[4].attrList}
+		}
+	case 59:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammer.y:320
+		{
+			yyVAL.node = &AutoNumberNode{spanOf(yyDollar[1].span, yyDollar[2].span), false, false, 0, 0, nil}
+		}
+	case 60:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammer.y:327
+		{
+			yyVAL.node = &DestroyNode{spanOf(yyDollar[1].span, yyDollar[2].span), yyDollar[2].actorRef, yyDollar[2].span}
+		}
+	case 61:
 		yyDollar = yyS[yypt-6 : yypt+1]
-//line grammer.y:197
+//line grammer.y:334
 		{
-			yyVAL.node = &NoteNode{yyDollar[3].actorRef, yyDollar[5].actorRef, yyDollar[2].noteAlign, yyDollar[6].sval}
+			yyVAL.node = &ActionNode{spanOf(yyDollar[1].span, yyDollar[6].span), yyDollar[1].actorRef, yyDollar[5].actorRef, yyDollar[2].arrow, yyDollar[6].sval, yyDollar[3].activationSh, yyDollar[4].ival, "", yyDollar[1].span, yyDollar[5].span, yyDollar[6].textBlock}
 		}
-	case 32:
+	case 62:
+		yyDollar = yyS[yypt-9 : yypt+1]
+//line grammer.y:338
+		{
+			yyVAL.node = &ActionNode{spanOf(yyDollar[1].span, yyDollar[9].span), yyDollar[4].actorRef, yyDollar[8].actorRef, yyDollar[5].arrow, yyDollar[9].sval, yyDollar[6].activationSh, yyDollar[7].ival, yyDollar[2].sval, yyDollar[4].span, yyDollar[8].span, yyDollar[9].textBlock}
+		}
+	case 63:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammer.y:342
+		{
+			// The lexer sends SYNC once it has skipped to the next statement.  Clearing the
+			// error flag, like yyerrok in C yacc, reports errors in that statement straight away.
+			Errflag = 0
+			yyVAL.node = nil
+		}
+	case 64:
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line grammer.y:352
+		{
+			yyVAL.node = &TimingConstraintNode{spanOf(yyDollar[1].span, yyDollar[6].span), yyDollar[2].sval, yyDollar[5].sval, yyDollar[6].sval, yyDollar[6].textBlock}
+		}
+	case 65:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammer.y:359
+		{
+			yyVAL.node = &StateNode{spanOf(yyDollar[1].span, yyDollar[3].span), yyDollar[2].actorRef, yyDollar[3].sval, yyDollar[2].span, yyDollar[3].textBlock}
+		}
+	case 66:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:366
+		{
+			yyVAL.node = &ReturnNode{spanOf(yyDollar[1].span), "", false}
+		}
+	case 67:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammer.y:370
+		{
+			yyVAL.node = &ReturnNode{spanOf(yyDollar[1].span, yyDollar[2].span), yyDollar[2].sval, yyDollar[2].textBlock}
+		}
+	case 68:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line grammer.y:376
+		{
+			yyVAL.activationSh = NO_ACTIVATION
+		}
+	case 69:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:377
+		{
+			yyVAL.activationSh = ACTIVATE_TARGET
+		}
+	case 70:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:204
+//line grammer.y:378
+		{
+			yyVAL.activationSh = DEACTIVATE_SOURCE
+		}
+	case 71:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line grammer.y:382
+		{
+			yyVAL.ival = 0
+		}
+	case 72:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammer.y:383
+		{
+			yyVAL.ival = yyDollar[2].ival
+		}
+	case 73:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammer.y:388
+		{
+			yyVAL.node = &ActivationNode{spanOf(yyDollar[1].span, yyDollar[2].span), yyDollar[2].actorRef, true, yyDollar[2].span}
+		}
+	case 74:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammer.y:392
+		{
+			yyVAL.node = &ActivationNode{spanOf(yyDollar[1].span, yyDollar[2].span), yyDollar[2].actorRef, false, yyDollar[2].span}
+		}
+	case 75:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line grammer.y:399
+		{
+			yyVAL.node = &NoteNode{spanOf(yyDollar[1].span, yyDollar[5].span), yyDollar[3].actorRef, nil, yyDollar[2].noteAlign, yyDollar[5].sval, appendAttrs(yyDollar[1].attrList, yyDollar[4].attrList), yyDollar[3].span, Span{}, yyDollar[5].textBlock}
+		}
+	case 76:
+		yyDollar = yyS[yypt-7 : yypt+1]
+//line grammer.y:403
+		{
+			yyVAL.node = &NoteNode{spanOf(yyDollar[1].span, yyDollar[7].span), yyDollar[3].actorRef, yyDollar[5].actorRef, yyDollar[2].noteAlign, yyDollar[7].sval, appendAttrs(yyDollar[1].attrList, yyDollar[6].attrList), yyDollar[3].span, yyDollar[5].span, yyDollar[7].textBlock}
+		}
+	case 77:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line grammer.y:407
+		{
+			yyVAL.node = &NoteNode{spanOf(yyDollar[1].span, yyDollar[4].span), nil, nil, ACROSS_NOTE_ALIGNMENT, yyDollar[4].sval, appendAttrs(yyDollar[1].attrList, yyDollar[3].attrList), Span{}, Span{}, yyDollar[4].textBlock}
+		}
+	case 78:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line grammer.y:411
+		{
+			// "on" is not a keyword so that it can be used as the name of an actor
+			if strings.ToLower(yyDollar[2].sval) != "on" {
+				yylex.(*parseState).unexpectedIdent(yyDollar[2].span, yyDollar[2].sval, "'left'", "'right'", "'over'", "'across'", "'on'")
+			} else if strings.ToLower(yyDollar[3].sval) != "message" {
+				yylex.(*parseState).unexpectedIdent(yyDollar[3].span, yyDollar[3].sval, "'message'")
+			}
+			yyVAL.node = &NoteNode{spanOf(yyDollar[1].span, yyDollar[5].span), nil, nil, ON_MESSAGE_NOTE_ALIGNMENT, yyDollar[5].sval, appendAttrs(yyDollar[1].attrList, yyDollar[4].attrList), Span{}, Span{}, yyDollar[5].textBlock}
+		}
+	case 79:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:424
+		{
+			yyVAL.attrList = nil
+		}
+	case 80:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:428
+		{
+			yyVAL.attrList = &AttributeList{&Attribute{yyDollar[1].span, "shape", "rounded"}, nil}
+		}
+	case 81:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:432
+		{
+			yyVAL.attrList = &AttributeList{&Attribute{yyDollar[1].span, "shape", "hexagon"}, nil}
+		}
+	case 82:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line grammer.y:439
+		{
+			yyVAL.node = &RefNode{spanOf(yyDollar[1].span, yyDollar[4].span), yyDollar[3].actorRef, nil, yyDollar[4].sval, yyDollar[3].span, Span{}, yyDollar[4].textBlock}
+		}
+	case 83:
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line grammer.y:443
+		{
+			yyVAL.node = &RefNode{spanOf(yyDollar[1].span, yyDollar[6].span), yyDollar[3].actorRef, yyDollar[5].actorRef, yyDollar[6].sval, yyDollar[3].span, yyDollar[5].span, yyDollar[6].textBlock}
+		}
+	case 84:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:450
 		{
 			yyVAL.actorRef = NormalActorRef(yyDollar[1].sval)
 		}
-	case 33:
+	case 85:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:454
+		{
+			yyVAL.actorRef = QuotedActorRef(yyDollar[1].sval)
+		}
+	case 86:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:208
+//line grammer.y:458
 		{
 			yyVAL.actorRef = PseudoActorRef("left")
 		}
-	case 34:
+	case 87:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:212
+//line grammer.y:462
 		{
 			yyVAL.actorRef = PseudoActorRef("right")
 		}
-	case 35:
+	case 88:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:466
+		{
+			yyVAL.actorRef = PseudoActorRef("found")
+		}
+	case 89:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:470
+		{
+			yyVAL.actorRef = PseudoActorRef("lost")
+		}
+	case 90:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:477
+		{
+			yyVAL.sval = yyDollar[1].sval
+		}
+	case 91:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:478
+		{
+			yyVAL.sval = yyDollar[1].sval
+		}
+	case 92:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:479
+		{
+			yyVAL.sval = yyDollar[1].sval
+		}
+	case 93:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:480
+		{
+			yyVAL.sval = yyDollar[1].sval
+		}
+	case 94:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:481
+		{
+			yyVAL.sval = yyDollar[1].sval
+		}
+	case 95:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line grammer.y:219
+//line grammer.y:486
 		{
-			yyVAL.node = &GapNode{yyDollar[2].dividerType, ""}
+			yyVAL.node = &GapNode{spanOf(yyDollar[1].span, yyDollar[2].span), yyDollar[2].dividerType, "", false}
 		}
-	case 36:
+	case 96:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammer.y:223
+//line grammer.y:490
 		{
-			yyVAL.node = &GapNode{yyDollar[2].dividerType, yyDollar[3].sval}
+			yyVAL.node = &GapNode{spanOf(yyDollar[1].span, yyDollar[3].span), yyDollar[2].dividerType, yyDollar[3].sval, yyDollar[3].textBlock}
 		}
-	case 37:
+	case 97:
 		yyDollar = yyS[yypt-5 : yypt+1]
-//line grammer.y:230
+//line grammer.y:497
 		{
-			yyVAL.node = &BlockNode{&BlockSegmentList{&BlockSegment{NONE_SEGMENT, "", yyDollar[3].sval, yyDollar[2].attrList, yyDollar[4].nodeList}, nil}}
+			yyVAL.node = &BlockNode{spanOf(yyDollar[1].span, yyDollar[5].span), &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[3].span), NONE_SEGMENT, "", yyDollar[3].sval, yyDollar[2].attrList, yyDollar[4].nodeList, nil, yyDollar[3].textBlock}, nil}}
 		}
-	case 38:
+	case 98:
 		yyDollar = yyS[yypt-6 : yypt+1]
-//line grammer.y:237
+//line grammer.y:504
 		{
-			yyVAL.node = &BlockNode{&BlockSegmentList{&BlockSegment{ALT_SEGMENT, "", yyDollar[3].sval, yyDollar[2].attrList, yyDollar[4].nodeList}, yyDollar[5].blockSegList}}
+			yyVAL.node = &BlockNode{spanOf(yyDollar[1].span, yyDollar[6].span), &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[3].span), ALT_SEGMENT, "", yyDollar[3].sval, yyDollar[2].attrList, yyDollar[4].nodeList, nil, yyDollar[3].textBlock}, yyDollar[5].blockSegList}}
 		}
-	case 39:
+	case 99:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line grammer.y:244
+//line grammer.y:511
 		{
 			yyVAL.blockSegList = nil
 		}
-	case 40:
+	case 100:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammer.y:248
+//line grammer.y:515
 		{
-			yyVAL.blockSegList = &BlockSegmentList{&BlockSegment{ALT_ELSE_SEGMENT, "", yyDollar[2].sval, nil, yyDollar[3].nodeList}, nil}
+			yyVAL.blockSegList = &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[2].span), ALT_ELSE_SEGMENT, "", yyDollar[2].sval, nil, yyDollar[3].nodeList, nil, yyDollar[2].textBlock}, nil}
 		}
-	case 41:
+	case 101:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line grammer.y:252
+//line grammer.y:519
 		{
-			yyVAL.blockSegList = &BlockSegmentList{&BlockSegment{ALT_SEGMENT, "", yyDollar[2].sval, nil, yyDollar[3].nodeList}, yyDollar[4].blockSegList}
+			yyVAL.blockSegList = &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[2].span), ALT_SEGMENT, "", yyDollar[2].sval, nil, yyDollar[3].nodeList, nil, yyDollar[2].textBlock}, yyDollar[4].blockSegList}
 		}
-	case 42:
+	case 102:
 		yyDollar = yyS[yypt-5 : yypt+1]
-//line grammer.y:259
+//line grammer.y:526
 		{
-			yyVAL.node = &BlockNode{&BlockSegmentList{&BlockSegment{PAR_SEGMENT, "", yyDollar[2].sval, nil, yyDollar[3].nodeList}, yyDollar[4].blockSegList}}
+			yyVAL.node = &BlockNode{spanOf(yyDollar[1].span, yyDollar[5].span), &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[2].span), PAR_SEGMENT, "", yyDollar[2].sval, nil, yyDollar[3].nodeList, nil, yyDollar[2].textBlock}, yyDollar[4].blockSegList}}
 		}
-	case 43:
+	case 103:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line grammer.y:266
+//line grammer.y:533
 		{
 			yyVAL.blockSegList = nil
 		}
-	case 44:
+	case 104:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammer.y:270
+//line grammer.y:537
 		{
-			yyVAL.blockSegList = &BlockSegmentList{&BlockSegment{PAR_ELSE_SEGMENT, "", yyDollar[2].sval, nil, yyDollar[3].nodeList}, nil}
+			yyVAL.blockSegList = &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[2].span), PAR_ELSE_SEGMENT, "", yyDollar[2].sval, nil, yyDollar[3].nodeList, nil, yyDollar[2].textBlock}, nil}
 		}
-	case 45:
+	case 105:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line grammer.y:274
+//line grammer.y:541
 		{
-			yyVAL.blockSegList = &BlockSegmentList{&BlockSegment{PAR_SEGMENT, "", yyDollar[2].sval, nil, yyDollar[3].nodeList}, yyDollar[4].blockSegList}
+			yyVAL.blockSegList = &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[2].span), PAR_SEGMENT, "", yyDollar[2].sval, nil, yyDollar[3].nodeList, nil, yyDollar[2].textBlock}, yyDollar[4].blockSegList}
 		}
-	case 46:
+	case 106:
 		yyDollar = yyS[yypt-5 : yypt+1]
-//line grammer.y:281
+//line grammer.y:548
 		{
-			yyVAL.node = &BlockNode{&BlockSegmentList{&BlockSegment{OPT_SEGMENT, "", yyDollar[3].sval, yyDollar[2].attrList, yyDollar[4].nodeList}, nil}}
+			yyVAL.node = &BlockNode{spanOf(yyDollar[1].span, yyDollar[5].span), &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[3].span), OPT_SEGMENT, "", yyDollar[3].sval, yyDollar[2].attrList, yyDollar[4].nodeList, nil, yyDollar[3].textBlock}, nil}}
 		}
-	case 47:
+	case 107:
 		yyDollar = yyS[yypt-5 : yypt+1]
-//line grammer.y:288
+//line grammer.y:555
 		{
-			yyVAL.node = &BlockNode{&BlockSegmentList{&BlockSegment{LOOP_SEGMENT, "", yyDollar[3].sval, yyDollar[2].attrList, yyDollar[4].nodeList}, nil}}
+			yyVAL.node = &BlockNode{spanOf(yyDollar[1].span, yyDollar[5].span), &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[3].span), LOOP_SEGMENT, "", yyDollar[3].sval, yyDollar[2].attrList, yyDollar[4].nodeList, nil, yyDollar[3].textBlock}, nil}}
 		}
-	case 48:
+	case 108:
 		yyDollar = yyS[yypt-5 : yypt+1]
-//line grammer.y:295
+//line grammer.y:562
 		{
-			yyVAL.node = &BlockNode{&BlockSegmentList{&BlockSegment{CONCURRENT_SEGMENT, "", "", nil, yyDollar[3].nodeList}, yyDollar[4].blockSegList}}
+			yyVAL.node = &BlockNode{spanOf(yyDollar[1].span, yyDollar[5].span), &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[3].span), yyDollar[1].segmentType, "", yyDollar[3].sval, yyDollar[2].attrList, yyDollar[4].nodeList, nil, yyDollar[3].textBlock}, nil}}
 		}
-	case 49:
+	case 109:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:568
+		{
+			yyVAL.segmentType = BREAK_SEGMENT
+		}
+	case 110:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:569
+		{
+			yyVAL.segmentType = CRITICAL_SEGMENT
+		}
+	case 111:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:570
+		{
+			yyVAL.segmentType = NEG_SEGMENT
+		}
+	case 112:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:571
+		{
+			yyVAL.segmentType = ASSERT_SEGMENT
+		}
+	case 113:
+		yyDollar = yyS[yypt-8 : yypt+1]
+//line grammer.y:576
+		{
+			yyVAL.node = &BlockNode{spanOf(yyDollar[1].span, yyDollar[8].span), &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[6].span), yyDollar[1].segmentType, "", yyDollar[6].sval, yyDollar[5].attrList, yyDollar[7].nodeList, yyDollar[3].strs, yyDollar[6].textBlock}, nil}}
+		}
+	case 114:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:582
+		{
+			yyVAL.segmentType = IGNORE_SEGMENT
+		}
+	case 115:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:583
+		{
+			yyVAL.segmentType = CONSIDER_SEGMENT
+		}
+	case 116:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:588
+		{
+			yyVAL.strs = []string{yyDollar[1].sval}
+		}
+	case 117:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammer.y:592
+		{
+			yyVAL.strs = append([]string{yyDollar[1].sval}, yyDollar[3].strs...)
+		}
+	case 118:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:598
+		{
+			yyVAL.sval = yyDollar[1].sval
+		}
+	case 119:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammer.y:599
+		{
+			yyVAL.sval = yyDollar[1].sval
+		}
+	case 120:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line grammer.y:604
+		{
+			yyVAL.node = &BlockNode{spanOf(yyDollar[1].span, yyDollar[5].span), &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[2].span), CONCURRENT_SEGMENT, "", "", nil, yyDollar[3].nodeList, nil, false}, yyDollar[4].blockSegList}}
+		}
+	case 121:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line grammer.y:302
+//line grammer.y:611
 		{
 			yyVAL.blockSegList = nil
 		}
-	case 50:
+	case 122:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line grammer.y:306
+//line grammer.y:615
 		{
-			yyVAL.blockSegList = &BlockSegmentList{&BlockSegment{CONCURRENT_WHILST_SEGMENT, "", "", nil, yyDollar[3].nodeList}, yyDollar[4].blockSegList}
+			yyVAL.blockSegList = &BlockSegmentList{&BlockSegment{spanOf(yyDollar[1].span, yyDollar[2].span), CONCURRENT_WHILST_SEGMENT, "", "", nil, yyDollar[3].nodeList, nil, false}, yyDollar[4].blockSegList}
 		}
-	case 51:
+	case 123:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:312
+//line grammer.y:621
 		{
 			yyVAL.dividerType = SPACER_GAP
 		}
-	case 52:
+	case 124:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:313
+//line grammer.y:622
 		{
 			yyVAL.dividerType = EMPTY_GAP
 		}
-	case 53:
+	case 125:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:314
+//line grammer.y:623
 		{
 			yyVAL.dividerType = LINE_GAP
 		}
-	case 54:
+	case 126:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:315
+//line grammer.y:624
 		{
 			yyVAL.dividerType = FRAME_GAP
 		}
-	case 55:
+	case 127:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line grammer.y:319
+//line grammer.y:628
 		{
 			yyVAL.noteAlign = LEFT_NOTE_ALIGNMENT
 		}
-	case 56:
+	case 128:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line grammer.y:320
+//line grammer.y:629
 		{
 			yyVAL.noteAlign = RIGHT_NOTE_ALIGNMENT
 		}
-	case 57:
+	case 129:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:321
+//line grammer.y:630
 		{
 			yyVAL.noteAlign = OVER_NOTE_ALIGNMENT
 		}
-	case 58:
+	case 130:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line grammer.y:326
+//line grammer.y:635
 		{
-			yyVAL.arrow = ArrowType{yyDollar[1].arrowStem, yyDollar[2].arrowHead}
+			yyVAL.arrow = ArrowType{yyDollar[1].arrowStem, yyDollar[2].arrowHead, FORWARD_ARROW}
 		}
-	case 59:
+	case 131:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammer.y:639
+		{
+			yyVAL.arrow = ArrowType{yyDollar[2].arrowStem, SOLID_ARROW_HEAD, BACKWARD_ARROW}
+		}
+	case 132:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammer.y:643
+		{
+			yyVAL.arrow = ArrowType{yyDollar[2].arrowStem, yyDollar[3].arrowHead, BIDIRECTIONAL_ARROW}
+		}
+	case 133:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:332
+//line grammer.y:649
 		{
 			yyVAL.arrowStem = SOLID_ARROW_STEM
 		}
-	case 60:
+	case 134:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:333
+//line grammer.y:650
 		{
 			yyVAL.arrowStem = DASHED_ARROW_STEM
 		}
-	case 61:
+	case 135:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:334
+//line grammer.y:651
 		{
 			yyVAL.arrowStem = THICK_ARROW_STEM
 		}
-	case 62:
+	case 136:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:338
+//line grammer.y:655
 		{
 			yyVAL.arrowHead = SOLID_ARROW_HEAD
 		}
-	case 63:
+	case 137:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:339
+//line grammer.y:656
 		{
 			yyVAL.arrowHead = OPEN_ARROW_HEAD
 		}
-	case 64:
+	case 138:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:340
+//line grammer.y:657
 		{
 			yyVAL.arrowHead = BARBED_ARROW_HEAD
 		}
-	case 65:
+	case 139:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammer.y:341
+//line grammer.y:658
 		{
 			yyVAL.arrowHead = LOWER_BARBED_ARROW_HEAD
 		}
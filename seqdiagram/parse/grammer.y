@@ -8,11 +8,11 @@ package parse
 import (
     "io"
     "bytes"
-    "errors"
     "strings"
-    "fmt"
+    "sort"
     "strconv"
     "text/scanner"
+    "unicode"
 )
 
 var DualRunes = map[string]int {
@@ -22,7 +22,9 @@ var DualRunes = map[string]int {
     "--":   DOUBLEDASH,
     "-":    DASH,
     "=":    EQUAL,
+    "+":    PLUS,
 
+    "<":    ANGL,
     ">>":   DOUBLEANGR,
     ">":    ANGR,
     "/>":   SLASHANGR,
@@ -36,16 +38,23 @@ var DualRunes = map[string]int {
     nodeList        *NodeList
     node            Node
     arrow           ArrowType
+    activationSh    ActivationShorthand
     arrowStem       ArrowStemType
     arrowHead       ArrowHeadType
     actorRef        ActorRef
+    actorNode       *ActorNode
+    span            Span
     noteAlign       NoteAlignment
     dividerType     GapType
     blockSegList    *BlockSegmentList
+    segmentType     SegmentType
     attrList        *AttributeList
     attr            *Attribute
+    strs            []string
 
     sval            string
+    ival            int
+    textBlock       bool
 }
 
 %token  K_TITLE K_PARTICIPANT K_NOTE K_STYLE
@@ -54,27 +63,50 @@ var DualRunes = map[string]int {
 %token  K_ALT   K_ELSEALT   K_ELSE   K_END  K_LOOP K_OPT
 %token  K_PAR K_ELSEPAR
 %token  K_CONCURRENT K_WHILST
-
-%token  DASH    DOUBLEDASH      DOT                 EQUAL       COMMA
+%token  K_ACTIVATE K_DEACTIVATE
+%token  K_CREATE K_DESTROY
+%token  K_AUTONUMBER K_OFF
+%token  K_INCLUDE
+%token  <sval>  K_REF
+%token  K_BREAK K_CRITICAL K_NEG K_ASSERT K_IGNORE K_CONSIDER
+%token  <sval>  K_BOX
+%token  K_ACROSS K_RNOTE K_HNOTE
+%token  K_CONSTRAINT K_AS
+%token  <sval>  K_STATE K_RETURN
+
+%token  DASH    DOUBLEDASH      DOT                 EQUAL       COMMA       PLUS
 %token  ANGR    DOUBLEANGR      BACKSLASHANGR       SLASHANGR
-%token  PARL    PARR
+%token  ANGL
+%token  PARL    PARR            BRACEL              BRACER
+%token  FOUND_ENDPOINT  LOST_ENDPOINT
+%token  SYNC
 
 %token  <sval>  STRING MESSAGE
 %token  <sval>  IDENT
+%token  <ival>  INT
 
 %type   <nodeList>      top decls
 %type   <node>          decl
 %type   <node>          title style actor action note gap altblock parblock parallelblock genericblock optblock loopblock
+%type   <node>          activation destroy autonumber include ref
+%type   <node>          fragmentblock messagefilterblock box timingconstraint state return
+%type   <nodeList>      boxactors
+%type   <segmentType>   fragmentType messageFilterType
+%type   <strs>          messagenames
+%type   <sval>          messagename
 %type   <arrow>         arrow
+%type   <activationSh>  activationShorthand
+%type   <ival>          delay
 %type   <actorRef>      actorref
+%type   <actorNode>     participantname
 %type   <arrowStem>     arrowStem
 %type   <arrowHead>     arrowHead
 %type   <noteAlign>     noteplace
 %type   <dividerType>   dividerType
 %type   <blockSegList>  altblocklist parblocklist parallelblocklist
-%type   <attrList>      maybeattrs attrs attrset
+%type   <attrList>      maybeattrs attrs attrset noteKeyword
 %type   <attr>          attr
-%type   <sval>          styleidentifier
+%type   <sval>          styleidentifier actorname
 
 %%
 
@@ -92,7 +124,11 @@ decls
     }
     |   decl decls
     {
-        $$ = &NodeList{$1, $2}
+        if $1 == nil {
+            $$ = $2
+        } else {
+            $$ = &NodeList{$1, $2}
+        }
     }
     ;
 
@@ -109,24 +145,39 @@ decl
     |   loopblock
     |   parallelblock
     |   genericblock
+    |   activation
+    |   destroy
+    |   autonumber
+    |   include
+    |   ref
+    |   fragmentblock
+    |   messagefilterblock
+    |   box
+    |   timingconstraint
+    |   state
+    |   return
      ;
 
 title
     :   K_TITLE MESSAGE
     {
-        $$ = &TitleNode{$2}
+        $$ = &TitleNode{spanOf($<span>1, $<span>2), $2, $<textBlock>2}
     }
     ;
 
 style
     :   K_STYLE styleidentifier attrset
     {
-        $$ = &StyleNode{$2, $3}
+        $$ = &StyleNode{spanOf($<span>1, $<span>3), $2, $3}
     }
     ;
 
 styleidentifier
     :   K_PARTICIPANT   { $$ = "participant"; }
+    |   K_AUTONUMBER    { $$ = "autonumber"; }
+    |   K_BOX           { $$ = "box"; }
+    |   K_NOTE          { $$ = "note"; }
+    |   K_BLOCK         { $$ = "block"; }
     |   IDENT           { $$ = $1; }
     ;
 
@@ -134,6 +185,7 @@ maybeattrs
     :   /* empty */
     {
         $$ = nil
+        $<span>$ = Span{}
     }
     |   attrset
     {
@@ -145,6 +197,7 @@ attrset
     :   PARL attrs PARR
     {
         $$ = $2;
+        $<span>$ = spanOf($<span>1, $<span>3)
     }
     ;
 
@@ -166,44 +219,241 @@ attrs
 attr
     :   IDENT EQUAL STRING
     {
-        $$ = &Attribute{$1, $3}
+        $$ = &Attribute{spanOf($<span>1, $<span>3), $1, $3}
     }
     ;
 
 actor
-    :   K_PARTICIPANT IDENT maybeattrs
+    :   K_PARTICIPANT participantname maybeattrs
+    {
+        $2.Span, $2.Attributes = spanOf($<span>1, $<span>2, $<span>3), $3
+        $$ = $2
+    }
+    |   K_PARTICIPANT participantname maybeattrs MESSAGE
+    {
+        $2.Span, $2.HasDescr, $2.Descr, $2.Attributes = spanOf($<span>1, $<span>4), true, $4, $3
+        $2.TextBlock = $<textBlock>4
+        $$ = $2
+    }
+    |   K_CREATE K_PARTICIPANT participantname maybeattrs
+    {
+        $3.Span, $3.Attributes, $3.Create = spanOf($<span>1, $<span>3, $<span>4), $4, true
+        $$ = $3
+    }
+    |   K_CREATE K_PARTICIPANT participantname maybeattrs MESSAGE
+    {
+        $3.Span, $3.HasDescr, $3.Descr, $3.Attributes, $3.Create = spanOf($<span>1, $<span>5), true, $5, $4, true
+        $3.TextBlock = $<textBlock>5
+        $$ = $3
+    }
+    ;
+
+participantname
+    :   actorname
+    {
+        $$ = &ActorNode{spanOf($<span>1), $1, false, "", nil, false, $<span>1, false, false}
+    }
+    |   STRING
+    {
+        $$ = &ActorNode{spanOf($<span>1), $1, false, "", nil, false, $<span>1, false, false}
+    }
+    |   actorname K_AS actorname
+    {
+        $$ = &ActorNode{spanOf($<span>1, $<span>3), $3, true, $1, nil, false, $<span>3, true, false}
+        $<span>$ = $$.Span
+    }
+    |   STRING K_AS actorname
     {
-        $$ = &ActorNode{$2, false, "", $3}
+        $$ = &ActorNode{spanOf($<span>1, $<span>3), $3, true, $1, nil, false, $<span>3, true, false}
+        $<span>$ = $$.Span
     }
-    |   K_PARTICIPANT IDENT maybeattrs MESSAGE
+    |   actorname K_AS STRING
     {
-        $$ = &ActorNode{$2, true, $4, $3}
+        $$ = &ActorNode{spanOf($<span>1, $<span>3), $1, true, $3, nil, false, $<span>1, true, false}
+        $<span>$ = $$.Span
+    }
+    ;
+
+box
+    :   K_BOX maybeattrs boxactors K_END
+    {
+        $$ = &BoxNode{spanOf($<span>1, $<span>4), "", $2, $3}
+    }
+    |   K_BOX STRING maybeattrs boxactors K_END
+    {
+        $$ = &BoxNode{spanOf($<span>1, $<span>5), $2, $3, $4}
+    }
+    ;
+
+boxactors
+    :   /* empty */
+    {
+        $$ = nil
+    }
+    |   actor boxactors
+    {
+        $$ = &NodeList{$1, $2}
+    }
+    ;
+
+include
+    :   K_INCLUDE STRING
+    {
+        $$ = &IncludeNode{spanOf($<span>1, $<span>2), $2}
+    }
+    ;
+
+autonumber
+    :   K_AUTONUMBER maybeattrs
+    {
+        $$ = &AutoNumberNode{spanOf($<span>1, $<span>2), true, false, 1, 1, $2}
+    }
+    |   K_AUTONUMBER INT maybeattrs
+    {
+        $$ = &AutoNumberNode{spanOf($<span>1, $<span>2, $<span>3), true, true, $2, 1, $3}
+    }
+    |   K_AUTONUMBER INT INT maybeattrs
+    {
+        $$ = &AutoNumberNode{spanOf($<span>1, $<span>3, $<span>4), true, true, $2, $3, $4}
+    }
+    |   K_AUTONUMBER K_OFF
+    {
+        $$ = &AutoNumberNode{spanOf($<span>1, $<span>2), false, false, 0, 0, nil}
+    }
+    ;
+
+destroy
+    :   K_DESTROY actorref
+    {
+        $$ = &DestroyNode{spanOf($<span>1, $<span>2), $2, $<span>2}
     }
     ;
 
 action
-    :   actorref arrow actorref MESSAGE
+    :   actorref arrow activationShorthand delay actorref MESSAGE
+    {
+        $$ = &ActionNode{spanOf($<span>1, $<span>6), $1, $5, $2, $6, $3, $4, "", $<span>1, $<span>5, $<textBlock>6}
+    }
+    |   BRACEL IDENT BRACER actorref arrow activationShorthand delay actorref MESSAGE
     {
-        $$ = &ActionNode{$1, $3, $2, $4}
+        $$ = &ActionNode{spanOf($<span>1, $<span>9), $4, $8, $5, $9, $6, $7, $2, $<span>4, $<span>8, $<textBlock>9}
+    }
+    |   error SYNC
+    {
+        // The lexer sends SYNC once it has skipped to the next statement.  Clearing the
+        // error flag, like yyerrok in C yacc, reports errors in that statement straight away.
+        Errflag = 0
+        $$ = nil
+    }
+    ;
+
+timingconstraint
+    :   K_CONSTRAINT IDENT DOT DOT IDENT MESSAGE
+    {
+        $$ = &TimingConstraintNode{spanOf($<span>1, $<span>6), $2, $5, $6, $<textBlock>6}
+    }
+    ;
+
+state
+    :   K_STATE actorref MESSAGE
+    {
+        $$ = &StateNode{spanOf($<span>1, $<span>3), $2, $3, $<span>2, $<textBlock>3}
+    }
+    ;
+
+return
+    :   K_RETURN
+    {
+        $$ = &ReturnNode{spanOf($<span>1), "", false}
+    }
+    |   K_RETURN MESSAGE
+    {
+        $$ = &ReturnNode{spanOf($<span>1, $<span>2), $2, $<textBlock>2}
+    }
+    ;
+
+activationShorthand
+    :   /* empty */         { $$ = NO_ACTIVATION }
+    |   PLUS                { $$ = ACTIVATE_TARGET }
+    |   DASH                { $$ = DEACTIVATE_SOURCE }
+    ;
+
+delay
+    :   /* empty */         { $$ = 0 }
+    |   PARL INT PARR       { $$ = $2 }
+    ;
+
+activation
+    :   K_ACTIVATE actorref
+    {
+        $$ = &ActivationNode{spanOf($<span>1, $<span>2), $2, true, $<span>2}
+    }
+    |   K_DEACTIVATE actorref
+    {
+        $$ = &ActivationNode{spanOf($<span>1, $<span>2), $2, false, $<span>2}
     }
     ;
 
 note
-    :   K_NOTE noteplace actorref MESSAGE
+    :   noteKeyword noteplace actorref maybeattrs MESSAGE
+    {
+        $$ = &NoteNode{spanOf($<span>1, $<span>5), $3, nil, $2, $5, appendAttrs($1, $4), $<span>3, Span{}, $<textBlock>5}
+    }
+    |   noteKeyword noteplace actorref COMMA actorref maybeattrs MESSAGE
+    {
+        $$ = &NoteNode{spanOf($<span>1, $<span>7), $3, $5, $2, $7, appendAttrs($1, $6), $<span>3, $<span>5, $<textBlock>7}
+    }
+    |   noteKeyword K_ACROSS maybeattrs MESSAGE
+    {
+        $$ = &NoteNode{spanOf($<span>1, $<span>4), nil, nil, ACROSS_NOTE_ALIGNMENT, $4, appendAttrs($1, $3), Span{}, Span{}, $<textBlock>4}
+    }
+    |   noteKeyword IDENT IDENT maybeattrs MESSAGE
     {
-        $$ = &NoteNode{$3, nil, $2, $4}
+        // "on" is not a keyword so that it can be used as the name of an actor
+        if strings.ToLower($2) != "on" {
+            yylex.(*parseState).unexpectedIdent($<span>2, $2, "'left'", "'right'", "'over'", "'across'", "'on'")
+        } else if strings.ToLower($3) != "message" {
+            yylex.(*parseState).unexpectedIdent($<span>3, $3, "'message'")
+        }
+        $$ = &NoteNode{spanOf($<span>1, $<span>5), nil, nil, ON_MESSAGE_NOTE_ALIGNMENT, $5, appendAttrs($1, $4), Span{}, Span{}, $<textBlock>5}
+    }
+    ;
+
+noteKeyword
+    :   K_NOTE
+    {
+        $$ = nil
+    }
+    |   K_RNOTE
+    {
+        $$ = &AttributeList{&Attribute{$<span>1, "shape", "rounded"}, nil}
     }
-    |   K_NOTE noteplace actorref COMMA actorref MESSAGE
+    |   K_HNOTE
     {
-        $$ = &NoteNode{$3, $5, $2, $6}
+        $$ = &AttributeList{&Attribute{$<span>1, "shape", "hexagon"}, nil}
+    }
+    ;
+
+ref
+    :   K_REF K_OVER actorref MESSAGE
+    {
+        $$ = &RefNode{spanOf($<span>1, $<span>4), $3, nil, $4, $<span>3, Span{}, $<textBlock>4}
+    }
+    |   K_REF K_OVER actorref COMMA actorref MESSAGE
+    {
+        $$ = &RefNode{spanOf($<span>1, $<span>6), $3, $5, $6, $<span>3, $<span>5, $<textBlock>6}
     }
     ;
 
 actorref
-    :   IDENT
+    :   actorname
     {
         $$ = NormalActorRef($1)
     }
+    |   STRING
+    {
+        $$ = QuotedActorRef($1)
+    }
     |   K_LEFT
     {
         $$ = PseudoActorRef("left")
@@ -212,30 +462,47 @@ actorref
     {
         $$ = PseudoActorRef("right")
     }
+    |   FOUND_ENDPOINT
+    {
+        $$ = PseudoActorRef("found")
+    }
+    |   LOST_ENDPOINT
+    {
+        $$ = PseudoActorRef("lost")
+    }
+    ;
+
+// Keywords which only start statements can also be used as the names of actors
+actorname
+    :   IDENT               { $$ = $1 }
+    |   K_REF               { $$ = $1 }
+    |   K_BOX               { $$ = $1 }
+    |   K_STATE             { $$ = $1 }
+    |   K_RETURN            { $$ = $1 }
     ;
 
 gap
     :   K_HORIZONTAL dividerType
     {
-        $$ = &GapNode{$2, ""}
+        $$ = &GapNode{spanOf($<span>1, $<span>2), $2, "", false}
     }
     |   K_HORIZONTAL dividerType MESSAGE
     {
-        $$ = &GapNode{$2, $3}
+        $$ = &GapNode{spanOf($<span>1, $<span>3), $2, $3, $<textBlock>3}
     }
     ;
 
 genericblock
     :   K_BLOCK maybeattrs MESSAGE decls K_END
     {
-        $$ = &BlockNode{&BlockSegmentList{&BlockSegment{NONE_SEGMENT, "", $3, $2, $4}, nil}}
+        $$ = &BlockNode{spanOf($<span>1, $<span>5), &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>3), NONE_SEGMENT, "", $3, $2, $4, nil, $<textBlock>3}, nil}}
     }
     ;
 
 altblock
     :   K_ALT maybeattrs MESSAGE decls altblocklist K_END
     {
-        $$ = &BlockNode{&BlockSegmentList{&BlockSegment{ALT_SEGMENT, "", $3, $2, $4}, $5}}
+        $$ = &BlockNode{spanOf($<span>1, $<span>6), &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>3), ALT_SEGMENT, "", $3, $2, $4, nil, $<textBlock>3}, $5}}
     }
     ;
 
@@ -246,18 +513,18 @@ altblocklist
     }
     |   K_ELSE MESSAGE decls
     {
-        $$ = &BlockSegmentList{&BlockSegment{ALT_ELSE_SEGMENT, "", $2, nil, $3}, nil}
+        $$ = &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>2), ALT_ELSE_SEGMENT, "", $2, nil, $3, nil, $<textBlock>2}, nil}
     }
     |   K_ELSEALT MESSAGE decls altblocklist
     {
-        $$ = &BlockSegmentList{&BlockSegment{ALT_SEGMENT, "", $2, nil, $3}, $4}
+        $$ = &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>2), ALT_SEGMENT, "", $2, nil, $3, nil, $<textBlock>2}, $4}
     }
     ;
 
 parblock
     :   K_PAR MESSAGE decls parblocklist K_END
     {
-        $$ = &BlockNode{&BlockSegmentList{&BlockSegment{PAR_SEGMENT, "", $2, nil, $3}, $4}}
+        $$ = &BlockNode{spanOf($<span>1, $<span>5), &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>2), PAR_SEGMENT, "", $2, nil, $3, nil, $<textBlock>2}, $4}}
     }
     ;
 
@@ -268,32 +535,74 @@ parblocklist
     }
     |   K_ELSE MESSAGE decls
     {
-        $$ = &BlockSegmentList{&BlockSegment{PAR_ELSE_SEGMENT, "", $2, nil, $3}, nil}
+        $$ = &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>2), PAR_ELSE_SEGMENT, "", $2, nil, $3, nil, $<textBlock>2}, nil}
     }
     |   K_ELSEPAR MESSAGE decls parblocklist
     {
-        $$ = &BlockSegmentList{&BlockSegment{PAR_SEGMENT, "", $2, nil, $3}, $4}
+        $$ = &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>2), PAR_SEGMENT, "", $2, nil, $3, nil, $<textBlock>2}, $4}
     }
     ;
 
 optblock
     :   K_OPT maybeattrs MESSAGE decls K_END
     {
-        $$ = &BlockNode{&BlockSegmentList{&BlockSegment{OPT_SEGMENT, "", $3, $2, $4}, nil}}
+        $$ = &BlockNode{spanOf($<span>1, $<span>5), &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>3), OPT_SEGMENT, "", $3, $2, $4, nil, $<textBlock>3}, nil}}
     }
     ;
 
 loopblock
     :   K_LOOP maybeattrs MESSAGE decls K_END
     {
-        $$ = &BlockNode{&BlockSegmentList{&BlockSegment{LOOP_SEGMENT, "", $3, $2, $4}, nil}}
+        $$ = &BlockNode{spanOf($<span>1, $<span>5), &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>3), LOOP_SEGMENT, "", $3, $2, $4, nil, $<textBlock>3}, nil}}
+    }
+    ;
+
+fragmentblock
+    :   fragmentType maybeattrs MESSAGE decls K_END
+    {
+        $$ = &BlockNode{spanOf($<span>1, $<span>5), &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>3), $1, "", $3, $2, $4, nil, $<textBlock>3}, nil}}
+    }
+    ;
+
+fragmentType
+    :   K_BREAK             { $$ = BREAK_SEGMENT }
+    |   K_CRITICAL          { $$ = CRITICAL_SEGMENT }
+    |   K_NEG               { $$ = NEG_SEGMENT }
+    |   K_ASSERT            { $$ = ASSERT_SEGMENT }
+    ;
+
+messagefilterblock
+    :   messageFilterType BRACEL messagenames BRACER maybeattrs MESSAGE decls K_END
+    {
+        $$ = &BlockNode{spanOf($<span>1, $<span>8), &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>6), $1, "", $6, $5, $7, $3, $<textBlock>6}, nil}}
     }
     ;
 
+messageFilterType
+    :   K_IGNORE            { $$ = IGNORE_SEGMENT }
+    |   K_CONSIDER          { $$ = CONSIDER_SEGMENT }
+    ;
+
+messagenames
+    :   messagename
+    {
+        $$ = []string{$1}
+    }
+    |   messagename COMMA messagenames
+    {
+        $$ = append([]string{$1}, $3...)
+    }
+    ;
+
+messagename
+    :   IDENT               { $$ = $1 }
+    |   STRING              { $$ = $1 }
+    ;
+
 parallelblock
     :   K_CONCURRENT MESSAGE decls parallelblocklist K_END
     {
-        $$ = &BlockNode{&BlockSegmentList{&BlockSegment{CONCURRENT_SEGMENT, "", "", nil, $3}, $4}}
+        $$ = &BlockNode{spanOf($<span>1, $<span>5), &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>2), CONCURRENT_SEGMENT, "", "", nil, $3, nil, false}, $4}}
     }
     ;
 
@@ -304,7 +613,7 @@ parallelblocklist
     }
     |   K_WHILST MESSAGE decls altblocklist
     {
-        $$ = &BlockSegmentList{&BlockSegment{CONCURRENT_WHILST_SEGMENT, "", "", nil, $3}, $4}
+        $$ = &BlockSegmentList{&BlockSegment{spanOf($<span>1, $<span>2), CONCURRENT_WHILST_SEGMENT, "", "", nil, $3, nil, false}, $4}
     }
     ;
 
@@ -324,7 +633,15 @@ noteplace
 arrow
     :   arrowStem   arrowHead
     {
-        $$ = ArrowType{$1, $2}
+        $$ = ArrowType{$1, $2, FORWARD_ARROW}
+    }
+    |   ANGL        arrowStem
+    {
+        $$ = ArrowType{$2, SOLID_ARROW_HEAD, BACKWARD_ARROW}
+    }
+    |   ANGL        arrowStem   arrowHead
+    {
+        $$ = ArrowType{$2, $3, BIDIRECTIONAL_ARROW}
     }
     ;
 
@@ -345,41 +662,152 @@ arrowHead
 // Manages the lexer as well as the current diagram being parsed
 type parseState struct {
     S           scanner.Scanner
-    err         error
+    errs        ErrorList
     atEof       bool
     //diagram     *Diagram
-    procInstrs  []string
+    procInstrs  []procInstr
+    comments    []*Comment
     nodeList    *NodeList
+
+    // The position of the most recently scanned token
+    tokLine     int
+    tokCol      int
+
+    // The end of the most recently scanned token, if it is not the scanner position
+    tokEnd      Pos
+
+    // The token most recently returned to the parser, and the kind of token returned
+    // before it
+    lastTok     lexedToken
+    prevTok     int
+
+    // Set after a syntax error, until the lexer has skipped to the next statement
+    recovering  bool
+
+    // A token to return to the parser again after recovering from a syntax error
+    replay      *lexedToken
+}
+
+// A processing instruction found in a comment
+type procInstr struct {
+    text        string
+    span        Span
+}
+
+// A token returned to the parser
+type lexedToken struct {
+    tok         int
+    lval        yySymType
+    text        string
+    line        int
+    col         int
+
+    // True if the token is the first on its line
+    firstOnLine bool
+
+    // True if the token is being returned again after recovering from a syntax error
+    replayed    bool
 }
 
 func newParseState(src io.Reader, filename string) *parseState {
     ps := &parseState{}
     ps.S.Init(src)
+    ps.S.Mode &^= scanner.SkipComments
     ps.S.Position.Filename = filename
+    ps.S.Error = func(s *scanner.Scanner, msg string) {
+        ps.Error(msg)
+    }
 //    ps.diagram = &Diagram{}
 
     return ps
 }
 
 func (ps *parseState) Lex(lval *yySymType) int {
+    if ps.recovering {
+        ps.recovering = false
+        ps.skipErrorLine()
+        return SYNC
+    } else if ps.replay != nil {
+        ps.prevTok = ps.lastTok.tok
+        ps.lastTok, ps.replay = *ps.replay, nil
+        *lval = ps.lastTok.lval
+        return ps.lastTok.tok
+    }
+
+    prevLine := ps.lastTok.line
+    ps.tokEnd = Pos{}
+    tok := ps.lex(lval)
+    lval.span = ps.tokSpan()
+    ps.prevTok = ps.lastTok.tok
+    ps.lastTok = lexedToken{tok, *lval, ps.S.TokenText(), ps.tokLine, ps.tokCol, ps.tokLine > prevLine, false}
+    return tok
+}
+
+// Returns the span of the most recently scanned token
+func (ps *parseState) tokSpan() Span {
+    end := ps.tokEnd
+    if end.Line == 0 {
+        pos := ps.S.Pos()
+        end = Pos{pos.Line, pos.Column}
+    }
+    return Span{ps.S.Filename, Pos{ps.tokLine, ps.tokCol}, end}
+}
+
+// Returns the position of the scanner, which is the position of the next character
+func (ps *parseState) pos() Pos {
+    pos := ps.S.Pos()
+    return Pos{pos.Line, pos.Column}
+}
+
+// Skips the remaining tokens on the line of the token which caused a syntax error, so that
+// parsing resumes at the next statement.  If the token was the first on its line, it is taken
+// to be the start of the next statement and is kept, unless it has already been returned
+// again after an earlier error.
+func (ps *parseState) skipErrorLine() {
+    errTok := ps.lastTok
+    for (!errTok.firstOnLine || errTok.replayed) && (errTok.tok != 0) {
+        var lval yySymType
+        ps.Lex(&lval)
+        if (ps.lastTok.tok == 0) || (ps.lastTok.line > errTok.line) {
+            break
+        }
+    }
+
+    replay := ps.lastTok
+    replay.replayed = true
+    ps.replay = &replay
+}
+
+func (ps *parseState) lex(lval *yySymType) int {
     if ps.atEof {
         return 0
     }
     for {
         tok := ps.S.Scan()
+        ps.tokLine, ps.tokCol = ps.S.Position.Line, ps.S.Position.Column
         switch tok {
         case scanner.EOF:
             ps.atEof = true
             return 0
         case '#':
             ps.scanComment()
+        case scanner.Comment:
+            ps.comments = append(ps.comments, &Comment{ps.tokSpan(), strings.TrimRight(ps.S.TokenText(), " \t\r")})
         case ':':
             return ps.scanMessage(lval)
         case '(':
             return PARL
         case ')':
             return PARR
-        case '-', '>', '*', '=', '/', '\\', '.', ',':
+        case '[':
+            if endpoint, isEndpoint := ps.scanEndpoint() ; isEndpoint {
+                return endpoint
+            }
+        case '{':
+            return BRACEL
+        case '}':
+            return BRACER
+        case '-', '<', '>', '*', '=', '/', '\\', '.', ',', '+':
             if res, isTok := ps.handleDoubleRune(tok) ; isTok {
                 return res
             } else {
@@ -393,6 +821,14 @@ func (ps *parseState) Lex(lval *yySymType) int {
             } else {
                 ps.Error("Invalid string: " + scanner.TokenString(tok) + ": " + err.Error())
             }
+        case scanner.Int:
+            tokVal := ps.S.TokenText()
+            if res, err := strconv.Atoi(tokVal) ; err == nil {
+                lval.ival = res
+                return INT
+            } else {
+                ps.Error("Invalid number: " + tokVal + ": " + err.Error())
+            }
         case scanner.Ident:
             return ps.scanKeywordOrIdent(lval)
         default:
@@ -422,66 +858,130 @@ func (ps *parseState) handleDoubleRune(firstRune rune) (int, bool) {
     return 0, false
 }
 
+// The keywords, which are matched regardless of case.  Any other word is an identifier.
+var keywords = map[string]int {
+    "title":         K_TITLE,
+    "participant":   K_PARTICIPANT,
+    "note":          K_NOTE,
+    "rnote":         K_RNOTE,
+    "hnote":         K_HNOTE,
+    "across":        K_ACROSS,
+    "constraint":    K_CONSTRAINT,
+    "state":         K_STATE,
+    "return":        K_RETURN,
+    "left":          K_LEFT,
+    "right":         K_RIGHT,
+    "over":          K_OVER,
+    "of":            K_OF,
+    "spacer":        K_SPACER,
+    "gap":           K_GAP,
+    "frame":         K_FRAME,
+    "block":         K_BLOCK,
+    "line":          K_LINE,
+    "style":         K_STYLE,
+    "horizontal":    K_HORIZONTAL,
+    "alt":           K_ALT,
+    "elsealt":       K_ELSEALT,
+    "par":           K_PAR,
+    "elsepar":       K_ELSEPAR,
+    "else":          K_ELSE,
+    "end":           K_END,
+    "loop":          K_LOOP,
+    "opt":           K_OPT,
+    "concurrent":    K_CONCURRENT,
+    "whilst":        K_WHILST,
+    "activate":      K_ACTIVATE,
+    "deactivate":    K_DEACTIVATE,
+    "create":        K_CREATE,
+    "destroy":       K_DESTROY,
+    "autonumber":    K_AUTONUMBER,
+    "off":           K_OFF,
+    "break":         K_BREAK,
+    "critical":      K_CRITICAL,
+    "neg":           K_NEG,
+    "assert":        K_ASSERT,
+    "ignore":        K_IGNORE,
+    "consider":      K_CONSIDER,
+    "box":           K_BOX,
+    "ref":           K_REF,
+    "include":       K_INCLUDE,
+}
+
+// Words which are only keywords within particular statements, where they are matched as
+// identifiers.  Elsewhere they can be used as identifiers.
+var contextKeywords = []string {
+    "as",
+    "on",
+}
+
 func (ps *parseState) scanKeywordOrIdent(lval *yySymType) int {
     tokVal := ps.S.TokenText()
-    switch strings.ToLower(tokVal) {
-    case "title":
-        return K_TITLE
-    case "participant":
-        return K_PARTICIPANT
-    case "note":
-        return K_NOTE
-    case "left":
-        return K_LEFT
-    case "right":
-        return K_RIGHT
-    case "over":
-        return K_OVER
-    case "of":
-        return K_OF
-    case "spacer":
-        return K_SPACER
-    case "gap":
-        return K_GAP
-    case "frame":
-        return K_FRAME
-    case "block":
-        return K_BLOCK
-    case "line":
-        return K_LINE
-    case "style":
-        return K_STYLE
-    case "horizontal":
-        return K_HORIZONTAL
-    case "alt":
-        return K_ALT
-    case "elsealt":
-        return K_ELSEALT
-    case "par":
-        return K_PAR
-    case "elsepar":
-        return K_ELSEPAR
-    case "else":
-        return K_ELSE
-    case "end":
-        return K_END
-    case "loop":
-        return K_LOOP
-    case "opt":
-        return K_OPT
-    case "concurrent":
-        return K_CONCURRENT
-    case "whilst":
-        return K_WHILST
+    tok, isKeyword := keywords[strings.ToLower(tokVal)]
+    switch {
+    case isKeyword:
+        lval.sval = tokVal
+        return tok
+    case ps.isAliasKeyword(tokVal):
+        return K_AS
     default:
         lval.sval = tokVal
         return IDENT
     }
 }
 
-// Scans a message.  A message is all characters up to the new line
+// Returns true if a word is the "as" of an alias, which directly follows the name in a
+// participant declaration.  Anywhere else, "as" is an identifier.
+func (ps *parseState) isAliasKeyword(word string) bool {
+    return (strings.ToLower(word) == "as") && (ps.prevTok == K_PARTICIPANT) && (ps.lastTok.line == ps.tokLine)
+}
+
+// Returns the keywords in alphabetical order
+func Keywords() []string {
+    words := make([]string, 0, len(keywords) + len(contextKeywords))
+    for word := range keywords {
+        words = append(words, word)
+    }
+    words = append(words, contextKeywords...)
+    sort.Strings(words)
+    return words
+}
+
+// Returns true if a name can be written as an identifier without quotes.  Keywords
+// cannot be used as identifiers.
+func IsIdent(name string) bool {
+    for i, r := range name {
+        if !((r == '_') || unicode.IsLetter(r) || ((i > 0) && unicode.IsDigit(r))) {
+            return false
+        }
+    }
+    _, isKeyword := keywords[strings.ToLower(name)]
+    return (name != "") && !isKeyword
+}
+
+// Scans a message.  A message is all characters up to the new line, or a text block
+// enclosed in triple quotes.
 func (ps *parseState) scanMessage(lval *yySymType) int {
     buf := new(bytes.Buffer)
+    lval.textBlock = false
+
+    for (ps.S.Peek() == ' ') || (ps.S.Peek() == '\t') {
+        ps.NextRune()
+    }
+
+    // Check for the start of a text block.  Any quotes which turn out not to start one are
+    // part of the message.
+    quotes := 0
+    for (quotes < 3) && (ps.S.Peek() == '"') {
+        ps.NextRune()
+        quotes++
+    }
+    if quotes == 3 {
+        return ps.scanTextBlock(lval)
+    }
+    buf.WriteString(strings.Repeat(`"`, quotes))
+
+    // The message ends before the new line
+    ps.tokEnd = ps.pos()
     r := ps.NextRune()
     for ((r != '\n') && (r != scanner.EOF)) {
         if (r == '\\') {
@@ -491,12 +991,17 @@ func (ps *parseState) scanMessage(lval *yySymType) int {
                 buf.WriteRune('\n')
             case '\\':
                 buf.WriteRune('\\')
+            case '*', '`', '~', '[', ']':
+                // Markup escapes are kept for the text box to interpret
+                buf.WriteRune('\\')
+                buf.WriteRune(nr)
             default:
                 ps.Error("Invalid backslash escape: \\" + string(nr))
             }
         } else {
             buf.WriteRune(r)
         }
+        ps.tokEnd = ps.pos()
         r = ps.NextRune()
     }
 
@@ -504,26 +1009,115 @@ func (ps *parseState) scanMessage(lval *yySymType) int {
     return MESSAGE
 }
 
-// Scans a comment.  This ignores all characters up to the new line.
+// Scans a text block.  A text block is all characters up to the closing triple quotes, with
+// no escapes.  A blank first and last line are dropped and any indentation common to all
+// lines is removed, so the text can be indented along with the rest of the file.
+func (ps *parseState) scanTextBlock(lval *yySymType) int {
+    buf := new(bytes.Buffer)
+    quotes := 0
+
+    for quotes < 3 {
+        r := ps.NextRune()
+        if r == scanner.EOF {
+            ps.Error("Unterminated text block: expected closing \"\"\"")
+            return MESSAGE
+        } else if r == '"' {
+            quotes++
+        } else {
+            buf.WriteString(strings.Repeat(`"`, quotes))
+            buf.WriteRune(r)
+            quotes = 0
+        }
+    }
+    ps.tokEnd = ps.pos()
+
+    // Only whitespace can follow the end of the block
+    for r := ps.NextRune() ; (r != '\n') && (r != scanner.EOF) ; r = ps.NextRune() {
+        if (r != ' ') && (r != '\t') && (r != '\r') {
+            ps.Error("Unexpected text after text block: " + string(r))
+            return MESSAGE
+        }
+    }
+
+    lval.sval = dedentTextBlock(buf.String())
+    lval.textBlock = true
+    return MESSAGE
+}
+
+// Removes the blank first and last lines and the common indentation from a text block
+func dedentTextBlock(text string) string {
+    lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+    if (len(lines) > 1) && (strings.TrimSpace(lines[0]) == "") {
+        lines = lines[1:]
+    }
+    if (len(lines) > 1) && (strings.TrimSpace(lines[len(lines) - 1]) == "") {
+        lines = lines[:len(lines) - 1]
+    }
+
+    indent := -1
+    for _, line := range lines {
+        if strings.TrimSpace(line) == "" {
+            continue
+        }
+        lineIndent := len(line) - len(strings.TrimLeft(line, " \t"))
+        if (indent == -1) || (lineIndent < indent) {
+            indent = lineIndent
+        }
+    }
+
+    for i, line := range lines {
+        line = strings.TrimRight(line, " \t")
+        if (indent > 0) && (len(line) >= indent) {
+            line = line[indent:]
+        }
+        lines[i] = line
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+// Scans the endpoint of a lost or found message.  These are either "[*]" or "[x]".
+func (ps *parseState) scanEndpoint() (int, bool) {
+    endpoint := 0
+
+    tok := ps.S.Scan()
+    if tok == '*' {
+        endpoint = FOUND_ENDPOINT
+    } else if (tok == scanner.Ident) && (strings.ToLower(ps.S.TokenText()) == "x") {
+        endpoint = LOST_ENDPOINT
+    }
+
+    if (endpoint == 0) || (ps.S.Scan() != ']') {
+        ps.Error("Invalid endpoint: expected [*] or [x]")
+        return 0, false
+    }
+    return endpoint, true
+}
+
+// Scans a comment.  This ignores all characters up to the new line.  Comments starting
+// with "#!" are processing instructions.
 func (ps *parseState) scanComment() {
-    var buf *bytes.Buffer
+    buf := new(bytes.Buffer)
 
+    end := ps.pos()
     r := ps.NextRune()
-    if (r == '!') {
-        // This starts a processor instruction
-        buf = new(bytes.Buffer)
+    isProcInstr := (r == '!')
+    if isProcInstr {
+        end = ps.pos()
         r = ps.NextRune()
     }
 
     for ((r != '\n') && (r != scanner.EOF)) {
-        if buf != nil {
-            buf.WriteRune(r)
-        }
+        buf.WriteRune(r)
+        end = ps.pos()
         r = ps.NextRune()
     }
 
-    if buf != nil {
-        ps.procInstrs = append(ps.procInstrs, strings.TrimSpace(buf.String()))
+    span := Span{ps.S.Filename, Pos{ps.tokLine, ps.tokCol}, end}
+    if isProcInstr {
+        ps.procInstrs = append(ps.procInstrs, procInstr{strings.TrimSpace(buf.String()), span})
+    } else {
+        ps.comments = append(ps.comments, &Comment{span, "#" + strings.TrimRight(buf.String(), " \t\r")})
     }
 }
 
@@ -540,26 +1134,78 @@ func (ps *parseState) NextRune() rune {
     return r
 }
 
+// Records an error.  Syntax errors reported by the parser are placed at the token which
+// caused them, and start the recovery to the next statement.
 func (ps *parseState) Error(err string) {
-    errMsg := fmt.Sprintf("%s:%d: %s", ps.S.Position.Filename, ps.S.Position.Line, err)
-    ps.err = errors.New(errMsg)
+    if strings.HasPrefix(err, "syntax error") {
+        ps.recovering = true
+
+        expected, found := parseSyntaxError(err)
+        switch ps.lastTok.tok {
+        case IDENT, STRING, INT:
+            found += " " + strconv.Quote(ps.lastTok.text)
+        }
+
+        msg := "Unexpected " + found
+        if len(expected) > 0 {
+            msg += ": expected " + joinAlternatives(expected)
+        }
+        ps.addError(&Error{ps.S.Filename, ps.lastTok.line, ps.lastTok.col, msg, expected, found})
+        return
+    }
+
+    // The position is invalidated when scanning messages rune by rune
+    line, col := ps.S.Position.Line, ps.S.Position.Column
+    if line == 0 {
+        pos := ps.S.Pos()
+        line, col = pos.Line, pos.Column
+    }
+
+    ps.addError(&Error{ps.S.Filename, line, col, err, nil, ""})
 }
 
+// Reports an identifier found where one of the expected words should be, like a syntax error
+func (ps *parseState) unexpectedIdent(span Span, ident string, expected ...string) {
+    found := "identifier " + strconv.Quote(ident)
+    msg := "Unexpected " + found + ": expected " + joinAlternatives(expected)
+    ps.addError(&Error{ps.S.Filename, span.Start.Line, span.Start.Column, msg, expected, found})
+}
+
+// Adds an error.  Only the first error on each line is kept, as any later errors on the
+// line are usually caused by it.
+func (ps *parseState) addError(err *Error) {
+    if (len(ps.errs) > 0) && (ps.errs[len(ps.errs) - 1].Line == err.Line) {
+        return
+    }
+    ps.errs = append(ps.errs, err)
+}
 
+// Parses a file.  If the file contains errors, the error returned is an ErrorList holding
+// every error found and the node list holds the statements which could be parsed.
 func Parse(reader io.Reader, filename string) (*NodeList, error) {
+    nl, _, err := ParseWithComments(reader, filename)
+    return nl, err
+}
+
+// Parses a file along with its comments.  The comments are returned in the order they
+// appear in the file.  Processing instructions are not comments and are returned as nodes.
+func ParseWithComments(reader io.Reader, filename string) (*NodeList, []*Comment, error) {
     ps := newParseState(reader, filename)
     yyParse(ps)
 
     // Add processing instructions to the start of the node list
     for i := len(ps.procInstrs) - 1; i >= 0; i-- {
-        instrParts := strings.SplitN(ps.procInstrs[i], " ", 2)
-        name, value := strings.TrimSpace(instrParts[0]), strings.TrimSpace(instrParts[1])
-        ps.nodeList = &NodeList{&ProcessInstructionNode{name, value}, ps.nodeList}
+        instrParts := strings.SplitN(ps.procInstrs[i].text, " ", 2)
+        name, value := strings.TrimSpace(instrParts[0]), ""
+        if len(instrParts) > 1 {
+            value = strings.TrimSpace(instrParts[1])
+        }
+        ps.nodeList = &NodeList{&ProcessInstructionNode{ps.procInstrs[i].span, name, value}, ps.nodeList}
     }
 
-    if ps.err != nil {
-        return nil, ps.err
+    if len(ps.errs) > 0 {
+        return ps.nodeList, ps.comments, ps.errs
     } else {
-        return ps.nodeList, nil
+        return ps.nodeList, ps.comments, nil
     }
 }
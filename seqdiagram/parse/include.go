@@ -0,0 +1,106 @@
+package parse
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ResolveIncludes replaces each include node within the node list, including those within blocks,
+// with the nodes parsed from the included file.  Included paths are resolved relative to the file
+// including them.  Processing instructions within included files are ignored.
+func ResolveIncludes(nl *NodeList, filename string) (*NodeList, error) {
+	var stack []string
+	if isIncludableFilename(filename) {
+		if absPath, err := filepath.Abs(filename); err == nil {
+			stack = []string{absPath}
+		}
+	}
+
+	return resolveIncludes(nl, filename, stack)
+}
+
+// Resolves the includes of a node list.  The stack contains the absolute paths of the files
+// currently being included, and is used to detect cycles.
+func resolveIncludes(nl *NodeList, filename string, stack []string) (*NodeList, error) {
+	nodes := make([]Node, 0)
+
+	for ; nl != nil; nl = nl.Tail {
+		switch n := nl.Head.(type) {
+		case *IncludeNode:
+			included, err := includeFile(n, filename, stack)
+			if err != nil {
+				return nil, err
+			}
+
+			for ; included != nil; included = included.Tail {
+				if _, isProcInstr := included.Head.(*ProcessInstructionNode); !isProcInstr {
+					nodes = append(nodes, included.Head)
+				}
+			}
+		case *BlockNode:
+			for segs := n.Segments; segs != nil; segs = segs.Tail {
+				subNodes, err := resolveIncludes(segs.Head.SubNodes, filename, stack)
+				if err != nil {
+					return nil, err
+				}
+				segs.Head.SubNodes = subNodes
+			}
+			nodes = append(nodes, n)
+		default:
+			nodes = append(nodes, n)
+		}
+	}
+
+	var resolved *NodeList
+	for i := len(nodes) - 1; i >= 0; i-- {
+		resolved = &NodeList{nodes[i], resolved}
+	}
+	return resolved, nil
+}
+
+// Parses the file referenced by an include node and resolves its includes
+func includeFile(in *IncludeNode, filename string, stack []string) (*NodeList, error) {
+	path := in.Path
+	if !filepath.IsAbs(path) && isIncludableFilename(filename) {
+		path = filepath.Join(filepath.Dir(filename), path)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, includeError(in, filename, fmt.Sprintf("cannot include %s: %s", in.Path, err.Error()))
+	}
+
+	for _, includingPath := range stack {
+		if includingPath == absPath {
+			return nil, includeError(in, filename, fmt.Sprintf("include cycle: %s -> %s", strings.Join(stack, " -> "), absPath))
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, includeError(in, filename, fmt.Sprintf("cannot include %s: %s", in.Path, err.Error()))
+	}
+	defer file.Close()
+
+	nl, err := Parse(file, path)
+	if err != nil {
+		return nil, err
+	}
+
+	includeStack := make([]string, len(stack), len(stack)+1)
+	copy(includeStack, stack)
+	return resolveIncludes(nl, path, append(includeStack, absPath))
+}
+
+// Returns an error positioned at an include node
+func includeError(in *IncludeNode, filename string, msg string) error {
+	return &Error{filename, in.Span.Start.Line, in.Span.Start.Column, msg, nil, ""}
+}
+
+// Returns true if the filename refers to a file that included paths can be resolved against.
+// Standard input is represented as either a blank filename or "-".
+func isIncludableFilename(filename string) bool {
+	return (filename != "") && (filename != "-")
+}
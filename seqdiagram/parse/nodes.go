@@ -30,12 +30,29 @@ const (
 	LOOP_SEGMENT                          = iota
 	CONCURRENT_SEGMENT                    = iota
 	CONCURRENT_WHILST_SEGMENT             = iota
+	BREAK_SEGMENT                         = iota
+	CRITICAL_SEGMENT                      = iota
+	NEG_SEGMENT                           = iota
+	ASSERT_SEGMENT                        = iota
+	IGNORE_SEGMENT                        = iota
+	CONSIDER_SEGMENT                      = iota
 	NONE_SEGMENT
 )
 
+// The direction of an arrow.  Backward arrows, such as "A<-B", point from the actor on the right
+// of the arrow to the actor on the left.
+type ArrowDirection int
+
+const (
+	FORWARD_ARROW       ArrowDirection = iota
+	BACKWARD_ARROW                     = iota
+	BIDIRECTIONAL_ARROW                = iota
+)
+
 type ArrowType struct {
 	Stem ArrowStemType
 	Head ArrowHeadType
+	Dir  ArrowDirection
 }
 
 // A list of declaration node
@@ -44,29 +61,90 @@ type NodeList struct {
 	Tail *NodeList
 }
 
+// A position within a source file.  Lines and columns start from 1.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+// The range of source a node was parsed from.  The end is the position immediately following
+// the last character of the node.
+type Span struct {
+	Filename string
+	Start    Pos
+	End      Pos
+}
+
+// Returns the span.  This is promoted to each node which embeds a span.
+func (s Span) NodeSpan() Span {
+	return s
+}
+
+// Returns true if the span is set
+func (s Span) IsValid() bool {
+	return s.Start.Line > 0
+}
+
+// Returns a span starting at the first span and ending at the end of the last valid span
+func spanOf(first Span, rest ...Span) Span {
+	span := first
+	for _, s := range rest {
+		if s.IsValid() {
+			span.End = s.End
+		}
+	}
+	return span
+}
+
 // A type of declaration node
 type Node interface {
+	NodeSpan() Span
+}
+
+// A comment.  The text includes the characters starting and ending the comment.
+type Comment struct {
+	Span
+
+	Text string
 }
 
 // A processing instruction node
 type ProcessInstructionNode struct {
+	Span
+
 	Prefix string
 	Value  string
 }
 
+// An include node.  These are replaced with the nodes of the included file by ResolveIncludes.
+type IncludeNode struct {
+	Span
+
+	Path string
+}
+
 // A title declaration node
 type TitleNode struct {
+	Span
+
 	Title string
+
+	// True if the title was written as a text block
+	TextBlock bool
 }
 
 // A style declaration node
 type StyleNode struct {
+	Span
+
 	Name       string
 	Attributes *AttributeList
 }
 
 // An actor declaration node
 type ActorNode struct {
+	Span
+
 	// Identifier
 	Ident string
 
@@ -78,6 +156,18 @@ type ActorNode struct {
 
 	// Attributes
 	Attributes *AttributeList
+
+	// True if the actor is created part way through the diagram
+	Create bool
+
+	// The span of the identifier
+	IdentSpan Span
+
+	// True if the description was given using "as", rather than as a message
+	Alias bool
+
+	// True if the description was written as a text block
+	TextBlock bool
 }
 
 // Returns a suitable actor name.  This can either be the description if HasDescr is true
@@ -94,18 +184,154 @@ func (an *ActorNode) ActorName() string {
 type ActorRef interface {
 }
 
+// An actor reference along with where it appears
+type SpannedActorRef struct {
+	Ref  ActorRef
+	Span Span
+}
+
+// Returns the actor references within a node.  This does not include the references within
+// the nodes of blocks.
+func ActorRefs(node Node) []SpannedActorRef {
+	var refs []SpannedActorRef
+	add := func(ref ActorRef, span Span) {
+		if ref != nil {
+			refs = append(refs, SpannedActorRef{ref, span})
+		}
+	}
+
+	switch n := node.(type) {
+	case *ActionNode:
+		add(n.From, n.FromSpan)
+		add(n.To, n.ToSpan)
+	case *NoteNode:
+		add(n.Actor1, n.Actor1Span)
+		add(n.Actor2, n.Actor2Span)
+	case *RefNode:
+		add(n.Actor1, n.Actor1Span)
+		add(n.Actor2, n.Actor2Span)
+	case *StateNode:
+		add(n.Actor, n.ActorSpan)
+	case *ActivationNode:
+		add(n.Actor, n.ActorSpan)
+	case *DestroyNode:
+		add(n.Actor, n.ActorSpan)
+	}
+	return refs
+}
+
 // A reference to a normal actor
 type NormalActorRef string
 
+// A reference to an actor written as a quoted string.  This can be either the actor's
+// identifier or its name.
+type QuotedActorRef string
+
 // A reference to a pseudo actor
 type PseudoActorRef string
 
+// Activation changes requested by the "+" and "-" arrow shorthands
+type ActivationShorthand int
+
+const (
+	NO_ACTIVATION     ActivationShorthand = iota
+	ACTIVATE_TARGET                       = iota
+	DEACTIVATE_SOURCE                     = iota
+)
+
 // An action node
 type ActionNode struct {
-	From  ActorRef
-	To    ActorRef
-	Arrow ArrowType
+	Span
+
+	From       ActorRef
+	To         ActorRef
+	Arrow      ArrowType
+	Descr      string
+	Activation ActivationShorthand
+
+	// The number of rows below the start of the message the message arrives on
+	Delay int
+
+	// The label used to refer to the message from timing constraints.  Empty if the
+	// message has no label.
+	Label string
+
+	// The spans of the actor references
+	FromSpan Span
+	ToSpan   Span
+
+	// True if the message was written as a text block
+	TextBlock bool
+}
+
+// A timing constraint between two labelled messages
+type TimingConstraintNode struct {
+	Span
+
+	FromLabel string
+	ToLabel   string
+	Descr     string
+
+	// True if the message was written as a text block
+	TextBlock bool
+}
+
+// A return from the most recent unanswered call
+type ReturnNode struct {
+	Span
+
 	Descr string
+
+	// True if the message was written as a text block
+	TextBlock bool
+}
+
+// A state invariant node
+type StateNode struct {
+	Span
+
+	Actor ActorRef
+	Descr string
+
+	ActorSpan Span
+
+	// True if the message was written as a text block
+	TextBlock bool
+}
+
+// An activate or deactivate node
+type ActivationNode struct {
+	Span
+
+	Actor    ActorRef
+	Activate bool
+
+	ActorSpan Span
+}
+
+// An autonumber node
+type AutoNumberNode struct {
+	Span
+
+	// False if numbering is to be turned off
+	Enabled bool
+
+	// True if a start number was given.  Otherwise numbering resumes from the last number.
+	HasStart bool
+
+	Start int
+	Step  int
+
+	Attributes *AttributeList
+}
+
+// A destroy node
+type DestroyNode struct {
+	Span
+
+	Actor ActorRef
+
+	ActorSpan Span
 }
 
 // Note node
@@ -115,14 +341,54 @@ const (
 	LEFT_NOTE_ALIGNMENT  NoteAlignment = iota
 	RIGHT_NOTE_ALIGNMENT               = iota
 	OVER_NOTE_ALIGNMENT                = iota
+	ACROSS_NOTE_ALIGNMENT              = iota
+	ON_MESSAGE_NOTE_ALIGNMENT          = iota
 )
 
 type NoteNode struct {
+	Span
+
+	Actor1 ActorRef // Nil for notes across all actors and notes on messages
+	Actor2 ActorRef // Can be nil
+
+	Position   NoteAlignment
+	Descr      string
+	Attributes *AttributeList
+
+	// The spans of the actor references.  These are not valid if the actor is nil.
+	Actor1Span Span
+	Actor2Span Span
+
+	// True if the message was written as a text block
+	TextBlock bool
+}
+
+// A box grouping a run of participant declarations
+type BoxNode struct {
+	Span
+
+	Title      string
+	Attributes *AttributeList
+
+	// The actor nodes declared within the box
+	Actors *NodeList
+}
+
+// A reference to another interaction, drawn as a frame over one or more actors
+type RefNode struct {
+	Span
+
 	Actor1 ActorRef
 	Actor2 ActorRef // Can be nil
 
-	Position NoteAlignment
-	Descr    string
+	Descr string
+
+	// The spans of the actor references.  The second span is not valid if the actor is nil.
+	Actor1Span Span
+	Actor2Span Span
+
+	// True if the message was written as a text block
+	TextBlock bool
 }
 
 // Gap node
@@ -136,12 +402,19 @@ const (
 )
 
 type GapNode struct {
+	Span
+
 	Type  GapType
 	Descr string
+
+	// True if the message was written as a text block
+	TextBlock bool
 }
 
 // A block node.  Each block can have one or more segments
 type BlockNode struct {
+	Span
+
 	Segments *BlockSegmentList
 }
 
@@ -151,15 +424,42 @@ type BlockSegmentList struct {
 }
 
 type BlockSegment struct {
+	Span
+
 	Type     SegmentType
 	Prefix   string
 	Message  string
 	AttributeList *AttributeList
 	SubNodes *NodeList
+
+	// Names of the messages ignored or considered by ignore and consider segments
+	MessageNames []string
+
+	// True if the message was written as a text block
+	TextBlock bool
+}
+
+// Calls a function for each node within a node list, including the nodes within blocks
+// and the actors declared within boxes
+func Walk(nl *NodeList, fn func(node Node)) {
+	for ; nl != nil; nl = nl.Tail {
+		fn(nl.Head)
+
+		switch n := nl.Head.(type) {
+		case *BlockNode:
+			for segs := n.Segments; segs != nil; segs = segs.Tail {
+				Walk(segs.Head.SubNodes, fn)
+			}
+		case *BoxNode:
+			Walk(n.Actors, fn)
+		}
+	}
 }
 
 // Attributes
 type Attribute struct {
+	Span
+
 	Name  string
 	Value string
 }
@@ -168,3 +468,11 @@ type AttributeList struct {
 	Head *Attribute
 	Tail *AttributeList
 }
+
+// Returns a list with the attributes of the second list following those of the first
+func appendAttrs(first *AttributeList, second *AttributeList) *AttributeList {
+	if first == nil {
+		return second
+	}
+	return &AttributeList{first.Head, appendAttrs(first.Tail, second)}
+}
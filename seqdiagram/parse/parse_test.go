@@ -0,0 +1,156 @@
+package parse
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseReportsEveryError(t *testing.T) {
+	src := strings.Join([]string{
+		"#!goseq",
+		"participant A",
+		"note ovr A: typo",
+		"A->B: fine",
+		"alt no colon",
+		"  A->B: fine",
+		"end",
+		"B->A: \\q",
+	}, "\n")
+
+	_, err := Parse(strings.NewReader(src), "test.seq")
+	errs, isErrorList := err.(ErrorList)
+	if !isErrorList {
+		t.Fatalf("expected an ErrorList but got %#v", err)
+	}
+
+	var positions [][2]int
+	for _, e := range errs {
+		positions = append(positions, [2]int{e.Line, e.Column})
+	}
+	if expected := [][2]int{{3, 6}, {5, 5}, {7, 1}, {8, 9}}; !reflect.DeepEqual(positions, expected) {
+		t.Errorf("expected errors at %v but got %v:\n%s", expected, positions, err)
+	}
+
+	if e := errs[1]; (e.Found != `identifier "no"`) || !reflect.DeepEqual(e.Expected, []string{"message"}) {
+		t.Errorf("expected found identifier and expected message but got %q and %q", e.Found, e.Expected)
+	}
+}
+
+func TestParseProcessingInstructionWithoutValue(t *testing.T) {
+	nl, err := Parse(strings.NewReader("#!goseq\n"), "test.seq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pi, isPi := nl.Head.(*ProcessInstructionNode)
+	if !isPi || (pi.Prefix != "goseq") || (pi.Value != "") {
+		t.Errorf("expected processing instruction goseq with no value but got %#v", nl.Head)
+	}
+}
+
+func TestParseNodeSpans(t *testing.T) {
+	src := strings.Join([]string{
+		"title: Spans",
+		`participant "Web Shop" as W (icon="x")`,
+		"alt: yes",
+		`  W->B: """`,
+		"    text",
+		`    """`,
+		"else: no",
+		"end",
+	}, "\n")
+
+	nl, err := Parse(strings.NewReader(src), "test.seq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := nl.Tail.Tail.Head.(*BlockNode)
+	spans := []Span{
+		nl.Head.NodeSpan(),
+		nl.Tail.Head.NodeSpan(),
+		block.Span,
+		block.Segments.Head.Span,
+		block.Segments.Head.SubNodes.Head.NodeSpan(),
+		block.Segments.Tail.Head.Span,
+	}
+	expected := []Span{
+		{"test.seq", Pos{1, 1}, Pos{1, 13}},
+		{"test.seq", Pos{2, 1}, Pos{2, 39}},
+		{"test.seq", Pos{3, 1}, Pos{8, 4}},
+		{"test.seq", Pos{3, 1}, Pos{3, 9}},
+		{"test.seq", Pos{4, 3}, Pos{6, 8}},
+		{"test.seq", Pos{7, 1}, Pos{7, 9}},
+	}
+	if !reflect.DeepEqual(spans, expected) {
+		t.Errorf("expected spans %v but got %v", expected, spans)
+	}
+}
+
+func TestParseKeywordsAsActorNames(t *testing.T) {
+	for _, name := range []string{"Ref", "Box", "On", "State", "Return", "As"} {
+		src := strings.Join([]string{
+			"participant " + name,
+			"A->" + name + ": call",
+			name + "-->A: reply",
+		}, "\n")
+
+		nl, err := Parse(strings.NewReader(src), "test.seq")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		actor := nl.Head.(*ActorNode)
+		call := nl.Tail.Head.(*ActionNode)
+		reply := nl.Tail.Tail.Head.(*ActionNode)
+		if (actor.Ident != name) || (call.To != NormalActorRef(name)) || (reply.From != NormalActorRef(name)) {
+			t.Errorf("%s: expected the keyword to be used as the actor name but got %#v, %#v and %#v", name, actor, call, reply)
+		}
+	}
+}
+
+func TestParseKeepsMarkupEscapes(t *testing.T) {
+	nl, err := Parse(strings.NewReader(`A->B: \[x\] \*y\* \\`), "test.seq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if action := nl.Head.(*ActionNode); action.Descr != `\[x\] \*y\* \` {
+		t.Errorf("expected the markup escapes to be kept but got %q", action.Descr)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	seeds, _ := filepath.Glob("../../tests/*.seq")
+	for _, seed := range seeds {
+		if src, err := os.ReadFile(seed); err == nil {
+			f.Add(string(src))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		nl, err := Parse(strings.NewReader(src), "fuzz.seq")
+		if err == nil {
+			for ; nl != nil; nl = nl.Tail {
+				if span := nl.Head.NodeSpan(); !span.IsValid() || (span.End.Line < span.Start.Line) {
+					t.Errorf("node with an invalid span: %#v", nl.Head)
+				}
+			}
+			return
+		}
+
+		errs, isErrorList := err.(ErrorList)
+		if !isErrorList || (len(errs) == 0) {
+			t.Fatalf("expected a non-empty ErrorList but got %#v", err)
+		}
+		for _, e := range errs {
+			if (e.Line < 1) || (e.Message == "") {
+				t.Errorf("error without a position or message: %#v", e)
+			}
+		}
+	})
+}
@@ -18,20 +18,41 @@ type DiagramStyles struct {
 
 	MultiNoteOverlap int
 
+	// Styling of the box drawn over lifelines for state invariants
+	StateBox graphbox.NoteBoxStyle
+
 	// Styling of the activity line
 	ActivityLine graphbox.ActivityLineStyle
 
 	// Styling of arrow heads
 	ArrowHeads map[ArrowHead]*graphbox.ArrowHeadStyle
 
+	// Styling of activation bars
+	Activation graphbox.ActivationStyle
+
+	// Styling of the marker drawn at the end of destroyed lifelines
+	DestroyMarker graphbox.DestroyMarkerStyle
+
 	// Styling of the diagram title
 	Title graphbox.TitleStyle
 
 	// Block styling
 	Block graphbox.BlockStyle
 
+	// Reference frame styling
+	Ref graphbox.RefStyle
+
+	// Styling of the boxes drawn behind groups of actors
+	GroupBox graphbox.GroupBoxStyle
+
 	// Styles of dividers
 	Divider map[DividerType]graphbox.DividerStyle
+
+	// Styling of the dimension lines of timing constraints
+	DimensionLine graphbox.DimensionLineStyle
+
+	// The width at which message, note and block text is wrapped.  Zero disables wrapping.
+	MaxTextWidth int
 }
 
 // Fonts
@@ -54,19 +75,34 @@ var DefaultStyle = &DiagramStyles{
 		IconGap:  4,
 	},
 	NoteBox: graphbox.NoteBoxStyle{
-		Font:     standardFont,
-		FontSize: 14,
-		Padding:  graphbox.Point{8, 4},
-		Margin:   graphbox.Point{8, 8},
+		Font:            standardFont,
+		FontSize:        14,
+		Padding:         graphbox.Point{8, 4},
+		Margin:          graphbox.Point{8, 8},
+		CornerSize:      10,
+		ConnectorLength: 16,
 	},
 	MultiNoteOverlap: 16,
+	StateBox: graphbox.NoteBoxStyle{
+		Font:       standardFont,
+		FontSize:   14,
+		Padding:    graphbox.Point{12, 4},
+		Margin:     graphbox.Point{8, 8},
+		Shape:      graphbox.RoundedNoteShape,
+		CornerSize: 14,
+		Color:      "#f4f4f4",
+	},
 	ActivityLine: graphbox.ActivityLineStyle{
-		Font:          standardFont,
-		FontSize:      14,
-		SelfRefWidth:  48,
-		SelfRefHeight: 24,
-		Margin:        graphbox.Point{16, 8},
-		TextGap:       4,
+		Font:            standardFont,
+		FontSize:        14,
+		SelfRefWidth:    48,
+		SelfRefHeight:   24,
+		Margin:          graphbox.Point{16, 8},
+		TextGap:         4,
+		NumberFontSize:  10,
+		NumberRadius:    8,
+		CircleEndLength: 48,
+		CircleEndRadius: 5,
 	},
 	ArrowHeads: map[ArrowHead]*graphbox.ArrowHeadStyle{
 		SolidArrowHead: {
@@ -90,6 +126,13 @@ var DefaultStyle = &DiagramStyles{
 			BaseStyle: "stroke:black;fill:black;stroke-width:2px;",
 		},
 	},
+	Activation: graphbox.ActivationStyle{
+		Width:     10,
+		MinHeight: 10,
+	},
+	DestroyMarker: graphbox.DestroyMarkerStyle{
+		Size: 16,
+	},
 	Title: graphbox.TitleStyle{
 		Font:     standardFont,
 		FontSize: 20,
@@ -106,6 +149,29 @@ var DefaultStyle = &DiagramStyles{
 		FontSize:  14,
 		MidMargin: 4,
 	},
+	Ref: graphbox.RefStyle{
+		Font:             standardFont,
+		FontSize:         14,
+		Padding:          graphbox.Point{16, 8},
+		Margin:           graphbox.Point{8, 8},
+		TextPadding:      graphbox.Point{4, 4},
+		PrefixExtraWidth: 4,
+		Overlap:          4,
+	},
+	GroupBox: graphbox.GroupBoxStyle{
+		Font:     standardFont,
+		FontSize: 14,
+		Padding:  graphbox.Point{6, 6},
+		TitleGap: 4,
+		Color:    "#eeeeee",
+	},
+	DimensionLine: graphbox.DimensionLineStyle{
+		Font:      standardFont,
+		FontSize:  14,
+		Gap:       8,
+		TextGap:   4,
+		ArrowSize: 6,
+	},
 	Divider: map[DividerType]graphbox.DividerStyle{
 		DTGap: {
 			Font:        standardFont,
@@ -160,19 +226,34 @@ var TightStyle = &DiagramStyles{
 		IconGap:  4,
 	},
 	NoteBox: graphbox.NoteBoxStyle{
-		Font:     standardFont,
-		FontSize: 14,
-		Padding:  graphbox.Point{8, 4},
-		Margin:   graphbox.Point{8, 4},
+		Font:            standardFont,
+		FontSize:        14,
+		Padding:         graphbox.Point{8, 4},
+		Margin:          graphbox.Point{8, 4},
+		CornerSize:      10,
+		ConnectorLength: 16,
 	},
 	MultiNoteOverlap: 16,
+	StateBox: graphbox.NoteBoxStyle{
+		Font:       standardFont,
+		FontSize:   14,
+		Padding:    graphbox.Point{12, 4},
+		Margin:     graphbox.Point{8, 4},
+		Shape:      graphbox.RoundedNoteShape,
+		CornerSize: 14,
+		Color:      "#f4f4f4",
+	},
 	ActivityLine: graphbox.ActivityLineStyle{
-		Font:          standardFont,
-		FontSize:      14,
-		SelfRefWidth:  48,
-		SelfRefHeight: 12,
-		Margin:        graphbox.Point{16, 4},
-		TextGap:       4,
+		Font:            standardFont,
+		FontSize:        14,
+		SelfRefWidth:    48,
+		SelfRefHeight:   12,
+		Margin:          graphbox.Point{16, 4},
+		TextGap:         4,
+		NumberFontSize:  10,
+		NumberRadius:    8,
+		CircleEndLength: 48,
+		CircleEndRadius: 5,
 	},
 	ArrowHeads: map[ArrowHead]*graphbox.ArrowHeadStyle{
 		SolidArrowHead: {
@@ -196,6 +277,13 @@ var TightStyle = &DiagramStyles{
 			BaseStyle: "stroke:black;fill:black;stroke-width:2px;",
 		},
 	},
+	Activation: graphbox.ActivationStyle{
+		Width:     10,
+		MinHeight: 10,
+	},
+	DestroyMarker: graphbox.DestroyMarkerStyle{
+		Size: 16,
+	},
 	Title: graphbox.TitleStyle{
 		Font:     standardFont,
 		FontSize: 20,
@@ -212,6 +300,29 @@ var TightStyle = &DiagramStyles{
 		FontSize:  14,
 		MidMargin: 4,
 	},
+	Ref: graphbox.RefStyle{
+		Font:             standardFont,
+		FontSize:         14,
+		Padding:          graphbox.Point{16, 8},
+		Margin:           graphbox.Point{8, 4},
+		TextPadding:      graphbox.Point{4, 4},
+		PrefixExtraWidth: 4,
+		Overlap:          4,
+	},
+	GroupBox: graphbox.GroupBoxStyle{
+		Font:     standardFont,
+		FontSize: 14,
+		Padding:  graphbox.Point{6, 4},
+		TitleGap: 2,
+		Color:    "#eeeeee",
+	},
+	DimensionLine: graphbox.DimensionLineStyle{
+		Font:      standardFont,
+		FontSize:  14,
+		Gap:       8,
+		TextGap:   4,
+		ArrowSize: 6,
+	},
 	Divider: map[DividerType]graphbox.DividerStyle{
 		DTGap: {
 			Font:        standardFont,
@@ -266,19 +377,34 @@ var SmallStyle = &DiagramStyles{
 		IconGap:  2,
 	},
 	NoteBox: graphbox.NoteBoxStyle{
-		Font:     standardFont,
-		FontSize: 12,
-		Padding:  graphbox.Point{6, 3},
-		Margin:   graphbox.Point{6, 6},
+		Font:            standardFont,
+		FontSize:        12,
+		Padding:         graphbox.Point{6, 3},
+		Margin:          graphbox.Point{6, 6},
+		CornerSize:      8,
+		ConnectorLength: 12,
 	},
 	MultiNoteOverlap: 8,
+	StateBox: graphbox.NoteBoxStyle{
+		Font:       standardFont,
+		FontSize:   12,
+		Padding:    graphbox.Point{9, 3},
+		Margin:     graphbox.Point{6, 6},
+		Shape:      graphbox.RoundedNoteShape,
+		CornerSize: 11,
+		Color:      "#f4f4f4",
+	},
 	ActivityLine: graphbox.ActivityLineStyle{
-		Font:          standardFont,
-		FontSize:      12,
-		Margin:        graphbox.Point{8, 8},
-		TextGap:       4,
-		SelfRefWidth:  32,
-		SelfRefHeight: 12,
+		Font:            standardFont,
+		FontSize:        12,
+		Margin:          graphbox.Point{8, 8},
+		TextGap:         4,
+		NumberFontSize:  9,
+		NumberRadius:    7,
+		CircleEndLength: 40,
+		CircleEndRadius: 4,
+		SelfRefWidth:    32,
+		SelfRefHeight:   12,
 	},
 	ArrowHeads: map[ArrowHead]*graphbox.ArrowHeadStyle{
 		SolidArrowHead: {
@@ -302,6 +428,13 @@ var SmallStyle = &DiagramStyles{
 			BaseStyle: "stroke:black;fill:black;stroke-width:2px;",
 		},
 	},
+	Activation: graphbox.ActivationStyle{
+		Width:     8,
+		MinHeight: 8,
+	},
+	DestroyMarker: graphbox.DestroyMarkerStyle{
+		Size: 12,
+	},
 	Title: graphbox.TitleStyle{
 		Font:     standardFont,
 		FontSize: 18,
@@ -318,6 +451,29 @@ var SmallStyle = &DiagramStyles{
 		FontSize:  12,
 		MidMargin: 2,
 	},
+	Ref: graphbox.RefStyle{
+		Font:             standardFont,
+		FontSize:         12,
+		Padding:          graphbox.Point{12, 6},
+		Margin:           graphbox.Point{6, 6},
+		TextPadding:      graphbox.Point{3, 2},
+		PrefixExtraWidth: 3,
+		Overlap:          2,
+	},
+	GroupBox: graphbox.GroupBoxStyle{
+		Font:     standardFont,
+		FontSize: 12,
+		Padding:  graphbox.Point{4, 4},
+		TitleGap: 2,
+		Color:    "#eeeeee",
+	},
+	DimensionLine: graphbox.DimensionLineStyle{
+		Font:      standardFont,
+		FontSize:  12,
+		Gap:       6,
+		TextGap:   3,
+		ArrowSize: 5,
+	},
 	Divider: map[DividerType]graphbox.DividerStyle{
 		DTGap: {
 			Font:        standardFont,
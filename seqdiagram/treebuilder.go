@@ -4,6 +4,7 @@ package seqdiagram
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/lmika/goseq/seqdiagram/parse"
@@ -23,9 +24,29 @@ var arrowHeadMap = map[parse.ArrowHeadType]ArrowHead{
 }
 
 var noteAlignmentMap = map[parse.NoteAlignment]NoteAlignment{
-	parse.LEFT_NOTE_ALIGNMENT:  LeftNoteAlignment,
-	parse.RIGHT_NOTE_ALIGNMENT: RightNoteAlignment,
-	parse.OVER_NOTE_ALIGNMENT:  OverNoteAlignment,
+	parse.LEFT_NOTE_ALIGNMENT:       LeftNoteAlignment,
+	parse.RIGHT_NOTE_ALIGNMENT:      RightNoteAlignment,
+	parse.OVER_NOTE_ALIGNMENT:       OverNoteAlignment,
+	parse.ACROSS_NOTE_ALIGNMENT:     AcrossNoteAlignment,
+	parse.ON_MESSAGE_NOTE_ALIGNMENT: OnMessageNoteAlignment,
+}
+
+var noteShapeMap = map[string]NoteShape{
+	"rect":    RectNoteShape,
+	"folded":  FoldedNoteShape,
+	"rounded": RoundedNoteShape,
+	"hexagon": HexagonNoteShape,
+}
+
+// The kinds of participants and the names of their icons
+var actorKindMap = map[string]ActorKind{
+	"participant": ParticipantActorKind,
+	"boundary":    BoundaryActorKind,
+	"control":     ControlActorKind,
+	"entity":      EntityActorKind,
+	"database":    DatabaseActorKind,
+	"collections": CollectionsActorKind,
+	"queue":       QueueActorKind,
 }
 
 var dividerTypeMap = map[parse.GapType]DividerType{
@@ -44,12 +65,35 @@ var segmentTypeMap = map[parse.SegmentType]SegmentType{
 	parse.LOOP_SEGMENT:              LoopSegmentType,
 	parse.CONCURRENT_SEGMENT:        ConcurrentSegmentType,
 	parse.CONCURRENT_WHILST_SEGMENT: ConcurrentWhilstSegmentType,
+	parse.BREAK_SEGMENT:             BreakSegmentType,
+	parse.CRITICAL_SEGMENT:          CriticalSegmentType,
+	parse.NEG_SEGMENT:               NegSegmentType,
+	parse.ASSERT_SEGMENT:            AssertSegmentType,
+	parse.IGNORE_SEGMENT:            IgnoreSegmentType,
+	parse.CONSIDER_SEGMENT:          ConsiderSegmentType,
 	parse.NONE_SEGMENT:              EmptySegmentType,
 }
 
 // styleIdentifierParticipant is the style identifier for participants
 const styleIdentifierParticipant = "participant"
 const styleIdentifierBlock = "block"
+const styleIdentifierAutoNumber = "autonumber"
+const styleIdentifierBox = "box"
+const styleIdentifierDiagram = "diagram"
+const styleIdentifierNote = "note"
+const styleIdentifierMessage = "message"
+
+// The names of the attributes read from each kind of declaration, keyed by the style
+// identifier used to style that kind of declaration
+var AttributeNames = map[string][]string{
+	styleIdentifierParticipant: {"kind", "stereotype", "icon", "header", "footer", "lifeline", "color", "textcolor"},
+	styleIdentifierBlock:       {"fullwidth", "maxwidth"},
+	styleIdentifierAutoNumber:  {"circle"},
+	styleIdentifierBox:         {"color"},
+	styleIdentifierDiagram:     {"maxwidth"},
+	styleIdentifierNote:        {"shape", "maxwidth"},
+	styleIdentifierMessage:     {"maxwidth"},
+}
 
 type treeBuilder struct {
 	nodeList *parse.NodeList
@@ -57,6 +101,42 @@ type treeBuilder struct {
 
 	// List of style definitions
 	styleDefs map[string]*AttributeSet
+
+	// The action of the previous declaration.  Nil if the previous declaration was not an
+	// action.  Used by notes on messages.
+	lastAction *Action
+
+	// Labelled actions, for timing constraints
+	labelledActions map[string]*Action
+
+	// The calls which have not been returned from, with the most recent call last.  Used
+	// to determine the target of returns.
+	callStack []call
+
+	// The actors which have been destroyed.  Messages cannot be sent to or from them.
+	destroyed map[*Actor]bool
+
+	// The created actors which have sent or received a message.  Created actors appear at
+	// their first message, so nothing else can be placed against them before it.
+	created map[*Actor]bool
+
+	// The span of the node being converted.  Used to position errors and actors created
+	// by referring to them.
+	span parse.Span
+
+	// True if the tree is being built for the lint rules.  Problems which are reported by a
+	// lint rule, such as unknown icons, are ignored so the rest of the diagram can be checked.
+	lint bool
+}
+
+// A call from one actor to another
+type call struct {
+	caller *Actor
+	callee *Actor
+
+	// True if the call activated the callee, either using the activation shorthand or
+	// an activate statement directly after the call
+	activated bool
 }
 
 func newTreeBuilder(nl *parse.NodeList, filename string) *treeBuilder {
@@ -64,19 +144,25 @@ func newTreeBuilder(nl *parse.NodeList, filename string) *treeBuilder {
 		nodeList:  nl,
 		filename:  filename,
 		styleDefs: make(map[string]*AttributeSet),
+
+		labelledActions: make(map[string]*Action),
+		destroyed:       make(map[*Actor]bool),
+		created:         make(map[*Actor]bool),
 	}
 }
 
 func (tb *treeBuilder) buildTree(d *Diagram) error {
 
 	for nodeList := tb.nodeList; nodeList != nil; nodeList = nodeList.Tail {
-		seqItem, err := tb.toSequenceItem(nodeList.Head, d)
+		seqItems, err := tb.toSequenceItems(nodeList.Head, d)
 		if err != nil {
 			return err
-		} else if seqItem != nil {
+		}
+		for _, seqItem := range seqItems {
 			d.AddSequenceItem(seqItem)
 		}
 	}
+	tb.groupActors(d)
 
 	return nil
 }
@@ -85,19 +171,72 @@ func (tb *treeBuilder) nodesToSlice(nodeList *parse.NodeList, d *Diagram) ([]Seq
 	seq := make([]SequenceItem, 0)
 
 	for ; nodeList != nil; nodeList = nodeList.Tail {
-		seqItem, err := tb.toSequenceItem(nodeList.Head, d)
+		seqItems, err := tb.toSequenceItems(nodeList.Head, d)
 		if err != nil {
 			return nil, err
-		} else if seqItem != nil {
-			seq = append(seq, seqItem)
 		}
+		seq = append(seq, seqItems...)
 	}
 
 	return seq, nil
 }
 
+// Returns an error positioned at the start of the node being converted
 func (tb *treeBuilder) makeError(msg string) error {
-	return fmt.Errorf("%s:%s", tb.filename, msg)
+	filename := tb.span.Filename
+	if filename == "" {
+		filename = tb.filename
+	}
+	return &parse.Error{filename, tb.span.Start.Line, tb.span.Start.Column, msg, nil, ""}
+}
+
+// Converts a node into zero or more sequence items.  Most nodes produce at most one item
+// but some, like actions using the activation shorthands, expand into several.
+func (tb *treeBuilder) toSequenceItems(node parse.Node, d *Diagram) ([]SequenceItem, error) {
+	parentSpan := tb.span
+	tb.span = node.NodeSpan()
+	defer func() { tb.span = parentSpan }()
+
+	switch n := node.(type) {
+	case *parse.ActionNode:
+		seqItems, err := tb.addActionWithActivation(n, d)
+		tb.setLastAction(seqItems)
+		return seqItems, err
+	case *parse.ReturnNode:
+		seqItems, err := tb.addReturn(n, d)
+		tb.setLastAction(seqItems)
+		return seqItems, err
+	default:
+		seqItem, err := tb.toSequenceItem(node, d)
+
+		// Notes on messages and items which apply to the previous action keep it
+		switch item := seqItem.(type) {
+		case *Activation:
+			tb.trackActivation(item)
+		case *Destroy, *AutoNumber, *TimingConstraint:
+		case *Note:
+			if item.Align != OnMessageNoteAlignment {
+				tb.lastAction = nil
+			}
+		default:
+			tb.lastAction = nil
+		}
+
+		if err != nil || seqItem == nil {
+			return nil, err
+		}
+		return []SequenceItem{seqItem}, nil
+	}
+}
+
+// Sets the last action to the action within the sequence items
+func (tb *treeBuilder) setLastAction(seqItems []SequenceItem) {
+	tb.lastAction = nil
+	for _, seqItem := range seqItems {
+		if action, isAction := seqItem.(*Action); isAction {
+			tb.lastAction = action
+		}
+	}
 }
 
 func (tb *treeBuilder) toSequenceItem(node parse.Node, d *Diagram) (SequenceItem, error) {
@@ -114,41 +253,87 @@ func (tb *treeBuilder) toSequenceItem(node parse.Node, d *Diagram) (SequenceItem
 	case *parse.ActorNode:
 		err := tb.addActor(n, d) // d.GetOrAddActorWithOptions(n.Ident, n.ActorName())
 		return nil, err
+	case *parse.BoxNode:
+		err := tb.addBox(n, d)
+		return nil, err
 	case *parse.ActionNode:
 		return tb.addAction(n, d)
 	case *parse.NoteNode:
 		return tb.addNote(n, d)
+	case *parse.RefNode:
+		return tb.addRef(n, d)
+	case *parse.TimingConstraintNode:
+		return tb.addTimingConstraint(n, d)
 	case *parse.GapNode:
 		return tb.addGap(n, d)
 	case *parse.BlockNode:
 		return tb.addBlock(n, d)
+	case *parse.StateNode:
+		return tb.addStateInvariant(n, d)
+	case *parse.ActivationNode:
+		return tb.addActivation(n.Actor, n.Activate, d)
+	case *parse.DestroyNode:
+		return tb.addDestroy(n, d)
+	case *parse.AutoNumberNode:
+		return tb.addAutoNumber(n, d)
 	case *parse.StyleNode:
-		if attrs, err := tb.attrsToMap(n.Attributes, tb.styleDefs[n.Name]); err == nil {
-			tb.styleDefs[n.Name] = attrs
-			return nil, nil
-		} else {
-			return nil, err
-		}
+		return nil, tb.addStyle(n, d)
 	default:
 		return nil, tb.makeError("Unrecognised declaration")
 	}
 }
 
+// Merges a style declaration into the style definitions.  The diagram style applies to the
+// diagram itself so is applied as it is declared.
+func (tb *treeBuilder) addStyle(sn *parse.StyleNode, d *Diagram) error {
+	attrs, err := tb.attrsToMap(sn.Attributes, tb.styleDefs[sn.Name])
+	if err != nil {
+		return err
+	}
+	tb.styleDefs[sn.Name] = attrs
+
+	if sn.Name == styleIdentifierDiagram {
+		maxWidth, err := tb.getMaxWidth(attrs)
+		if err != nil {
+			return err
+		}
+		d.MaxTextWidth = maxWidth
+	}
+	return nil
+}
+
 func (tb *treeBuilder) addActor(an *parse.ActorNode, d *Diagram) error {
 	actor := d.GetOrAddActorWithOptions(an.Ident, an.ActorName())
+	actor.Span = an.Span
 	parentStyle := tb.styleDefs[styleIdentifierParticipant]
 
 	attrMap, err := tb.attrsToMap(an.Attributes, parentStyle)
 	if err != nil {
 		return err
 	}
+	actor.Attributes = attrMap
 
 	// Configure the attributes
-	if iconName, hasIconName := attrMap.Get("icon"); hasIconName && (iconName != "none") {
+	kindName := attrMap.GetDef("kind", "participant")
+	kind, hasKind := actorKindMap[strings.ToLower(kindName)]
+	if !hasKind {
+		return tb.makeError("Unknown participant kind: " + kindName)
+	}
+	actor.Kind = kind
+	actor.Stereotype = attrMap.GetDef("stereotype", "")
+
+	// Participant kinds are drawn with the icon of the same name unless another is given
+	if kind != ParticipantActorKind {
+		actor.Icon, _ = LookupActorIcon(strings.ToLower(kindName))
+	}
+
+	if iconName, hasIconName := attrMap.Get("icon"); hasIconName && (iconName == "none") {
+		actor.Icon = nil
+	} else if hasIconName {
 		if icon, err := LookupActorIcon(iconName); err == nil {
 			actor.Icon = icon
-		} else {
-			return fmt.Errorf("error loading icon '%s': %s", iconName, err.Error())
+		} else if !tb.lint {
+			return tb.makeError(fmt.Sprintf("error loading icon '%s': %s", iconName, err.Error()))
 		}
 	}
 
@@ -157,10 +342,67 @@ func (tb *treeBuilder) addActor(an *parse.ActorNode, d *Diagram) error {
 	actor.Lifeline = attrMap.GetDef("lifeline", "dashed") != "none"
 	actor.Color = attrMap.GetDef("color", "black")
 	actor.TextColor = attrMap.GetDef("textcolor", actor.Color)
+	actor.Created = an.Create
+
+	return nil
+}
+
+// Adds the actors declared within a box and places them in a new group
+func (tb *treeBuilder) addBox(bn *parse.BoxNode, d *Diagram) error {
+	attrMap, err := tb.attrsToMap(bn.Attributes, tb.styleDefs[styleIdentifierBox])
+	if err != nil {
+		return err
+	}
+
+	group := &ActorGroup{
+		Title: bn.Title,
+		Color: attrMap.GetDef("color", ""),
+	}
 
+	for nl := bn.Actors; nl != nil; nl = nl.Tail {
+		tb.span = nl.Head.NodeSpan()
+		an, isActorNode := nl.Head.(*parse.ActorNode)
+		if !isActorNode {
+			return tb.makeError("Boxes can only contain participant declarations")
+		}
+
+		if err := tb.addActor(an, d); err != nil {
+			return err
+		}
+
+		actor := d.GetOrAddActor(an.Ident)
+		if actor.Group != nil {
+			return tb.makeError(fmt.Sprintf("Participant %s is already within a box", an.Ident))
+		}
+		actor.Group = group
+		group.Actors = append(group.Actors, actor)
+	}
+
+	d.ActorGroups = append(d.ActorGroups, group)
 	return nil
 }
 
+// Reorders the actors so that the actors within a group are next to each other.  Each group
+// is placed at the position of its first actor.
+func (tb *treeBuilder) groupActors(d *Diagram) {
+	actors := make([]*Actor, 0, len(d.Actors))
+	placedGroups := make(map[*ActorGroup]bool)
+
+	for _, actor := range d.Actors {
+		if actor.Group == nil {
+			actors = append(actors, actor)
+		} else if !placedGroups[actor.Group] {
+			actors = append(actors, actor.Group.Actors...)
+			placedGroups[actor.Group] = true
+		}
+	}
+
+	for i, actor := range actors {
+		actor.rank = i
+	}
+	d.Actors = actors
+}
+
 func (tb *treeBuilder) addAction(an *parse.ActionNode, d *Diagram) (SequenceItem, error) {
 	from, err := tb.getOrAddActor(an.From, d)
 	if err != nil {
@@ -172,12 +414,256 @@ func (tb *treeBuilder) addAction(an *parse.ActionNode, d *Diagram) (SequenceItem
 		return nil, err
 	}
 
-	arrow := Arrow{arrowStemMap[an.Arrow.Stem], arrowHeadMap[an.Arrow.Head]}
-	action := &Action{from, to, arrow, an.Descr}
+	if (from.isEndpoint() && (to.rank < 0)) || (to.isEndpoint() && (from.rank < 0)) {
+		return nil, tb.makeError("Found and lost messages must start or end at a participant")
+	}
+
+	if err := tb.checkNotDestroyed(from, to); err != nil {
+		return nil, err
+	}
+	tb.created[from], tb.created[to] = true, true
+
+	if (an.Delay > 0) && ((from == to) || from.isEndpoint() || to.isEndpoint()) {
+		return nil, tb.makeError("Delays can only be used on messages between two different participants")
+	}
+
+	// Backward arrows are sent from the actor on the right of the arrow
+	if an.Arrow.Dir == parse.BACKWARD_ARROW {
+		from, to = to, from
+	}
+
+	maxWidth, err := tb.getMessageMaxWidth()
+	if err != nil {
+		return nil, err
+	}
+
+	arrow := Arrow{arrowStemMap[an.Arrow.Stem], arrowHeadMap[an.Arrow.Head], an.Arrow.Dir == parse.BIDIRECTIONAL_ARROW}
+	action := &Action{from, to, arrow, an.Descr, an.Delay, an.Label, maxWidth, an.Span}
+	if an.Label != "" {
+		if _, hasLabel := tb.labelledActions[an.Label]; hasLabel {
+			return nil, tb.makeError("Message label already used: " + an.Label)
+		}
+		tb.labelledActions[an.Label] = action
+	}
 	return action, nil
 }
 
+func (tb *treeBuilder) addTimingConstraint(tn *parse.TimingConstraintNode, d *Diagram) (SequenceItem, error) {
+	from, hasFrom := tb.labelledActions[tn.FromLabel]
+	if !hasFrom {
+		return nil, tb.makeError("Unknown message label: " + tn.FromLabel)
+	}
+
+	to, hasTo := tb.labelledActions[tn.ToLabel]
+	if !hasTo {
+		return nil, tb.makeError("Unknown message label: " + tn.ToLabel)
+	}
+
+	return &TimingConstraint{from, to, tn.Descr}, nil
+}
+
+// Adds an action along with any activation changes requested using the arrow shorthands
+func (tb *treeBuilder) addActionWithActivation(an *parse.ActionNode, d *Diagram) ([]SequenceItem, error) {
+	action, err := tb.addAction(an, d)
+	if err != nil {
+		return nil, err
+	}
+
+	tb.trackCall(action.(*Action), an.Activation == parse.ACTIVATE_TARGET)
+
+	source, target := an.From, an.To
+	if an.Arrow.Dir == parse.BACKWARD_ARROW {
+		source, target = target, source
+	}
+
+	var activation SequenceItem
+	switch an.Activation {
+	case parse.ACTIVATE_TARGET:
+		activation, err = tb.addActivation(target, true, d)
+	case parse.DEACTIVATE_SOURCE:
+		activation, err = tb.addActivation(source, false, d)
+	default:
+		return []SequenceItem{action}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []SequenceItem{action, activation}, nil
+}
+
+// Updates the call stack with an action.  Actions with a dashed stem are treated as
+// replies and answer the most recent call they reply to, along with any calls made after
+// it.  All other actions between actors are treated as calls.
+func (tb *treeBuilder) trackCall(action *Action, activated bool) {
+	if action.From.isEndpoint() || action.To.isEndpoint() || action.Arrow.Bidirectional {
+		return
+	}
+
+	if action.Arrow.Stem == DashedArrowStem {
+		for i := len(tb.callStack) - 1; i >= 0; i-- {
+			if (tb.callStack[i].callee == action.From) && (tb.callStack[i].caller == action.To) {
+				tb.callStack = tb.callStack[:i]
+				return
+			}
+		}
+		return
+	}
+
+	tb.callStack = append(tb.callStack, call{action.From, action.To, activated})
+}
+
+// Updates the call stack with an explicit activation.  Activating the callee of the most
+// recent call directly after the call has the same effect as the activation shorthand, and
+// deactivating the callee means the return no longer needs to.
+func (tb *treeBuilder) trackActivation(activation *Activation) {
+	if len(tb.callStack) == 0 {
+		return
+	}
+
+	top := &tb.callStack[len(tb.callStack)-1]
+	if activation.Actor != top.callee {
+		return
+	}
+
+	if !activation.Activate {
+		top.activated = false
+	} else if (tb.lastAction != nil) && (tb.lastAction.From == top.caller) && (tb.lastAction.To == top.callee) {
+		top.activated = true
+	}
+}
+
+// Adds a return from the most recent unanswered call.  If the call activated the callee,
+// the return deactivates it.
+func (tb *treeBuilder) addReturn(rn *parse.ReturnNode, d *Diagram) ([]SequenceItem, error) {
+	if len(tb.callStack) == 0 {
+		return nil, tb.makeError("Return does not follow an unanswered call")
+	}
+
+	call := tb.callStack[len(tb.callStack)-1]
+	tb.callStack = tb.callStack[:len(tb.callStack)-1]
+	if err := tb.checkNotDestroyed(call.callee, call.caller); err != nil {
+		return nil, err
+	}
+
+	maxWidth, err := tb.getMessageMaxWidth()
+	if err != nil {
+		return nil, err
+	}
+
+	action := &Action{call.callee, call.caller, Arrow{DashedArrowStem, OpenArrowHead, false}, rn.Descr, 0, "", maxWidth, rn.Span}
+	if call.activated {
+		return []SequenceItem{action, &Activation{call.callee, false}}, nil
+	}
+	return []SequenceItem{action}, nil
+}
+
+func (tb *treeBuilder) addActivation(ar parse.ActorRef, activate bool, d *Diagram) (SequenceItem, error) {
+	actor, err := tb.getOrAddActor(ar, d)
+	if err != nil {
+		return nil, err
+	} else if actor.rank < 0 {
+		return nil, tb.makeError("Cannot activate or deactivate pseudo actors")
+	} else if err := tb.checkCreated(actor); err != nil {
+		return nil, err
+	}
+
+	return &Activation{actor, activate}, nil
+}
+
+func (tb *treeBuilder) addDestroy(dn *parse.DestroyNode, d *Diagram) (SequenceItem, error) {
+	actor, err := tb.getOrAddActor(dn.Actor, d)
+	if err != nil {
+		return nil, err
+	} else if actor.rank < 0 {
+		return nil, tb.makeError("Cannot destroy pseudo actors")
+	} else if err := tb.checkCreated(actor); err != nil {
+		return nil, err
+	}
+
+	tb.destroyed[actor] = true
+	return &Destroy{actor}, nil
+}
+
+// Returns an error if any of the actors have been destroyed
+func (tb *treeBuilder) checkNotDestroyed(actors ...*Actor) error {
+	for _, actor := range actors {
+		if tb.destroyed[actor] {
+			return tb.makeError(fmt.Sprintf("Participant %s has been destroyed", actor.Name))
+		}
+	}
+	return nil
+}
+
+// Returns an error if any of the actors are created but have not yet sent or received
+// their first message
+func (tb *treeBuilder) checkCreated(actors ...*Actor) error {
+	for _, actor := range actors {
+		if (actor != nil) && actor.Created && !tb.created[actor] {
+			return tb.makeError(fmt.Sprintf("Participant %s is used before the first message which creates it", actor.Name))
+		}
+	}
+	return nil
+}
+
+func (tb *treeBuilder) addStateInvariant(sn *parse.StateNode, d *Diagram) (SequenceItem, error) {
+	actor, err := tb.getOrAddActor(sn.Actor, d)
+	if err != nil {
+		return nil, err
+	} else if actor.isEndpoint() {
+		return nil, tb.makeError("States cannot be placed over found or lost message endpoints")
+	} else if err := tb.checkCreated(actor); err != nil {
+		return nil, err
+	}
+
+	return &StateInvariant{actor, sn.Descr}, nil
+}
+
+func (tb *treeBuilder) addAutoNumber(an *parse.AutoNumberNode, d *Diagram) (SequenceItem, error) {
+	attrs, err := tb.attrsToMap(an.Attributes, tb.styleDefs[styleIdentifierAutoNumber])
+	if err != nil {
+		return nil, err
+	}
+
+	return &AutoNumber{
+		Enabled: an.Enabled,
+		Resume:  !an.HasStart,
+		Start:   an.Start,
+		Step:    an.Step,
+		Circle:  attrs.GetBool("circle", false),
+	}, nil
+}
+
 func (tb *treeBuilder) addNote(nn *parse.NoteNode, d *Diagram) (SequenceItem, error) {
+	attrs, err := tb.attrsToMap(nn.Attributes, tb.styleDefs[styleIdentifierNote])
+	if err != nil {
+		return nil, err
+	}
+
+	shapeName := attrs.GetDef("shape", "rect")
+	shape, hasShape := noteShapeMap[strings.ToLower(shapeName)]
+	if !hasShape {
+		return nil, tb.makeError("Unknown note shape: " + shapeName)
+	}
+
+	maxWidth, err := tb.getMaxWidth(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Notes on messages are placed against the previous action
+	if nn.Position == parse.ON_MESSAGE_NOTE_ALIGNMENT {
+		if tb.lastAction == nil {
+			return nil, tb.makeError("Notes on messages must directly follow a message")
+		}
+		return &Note{nil, nil, OnMessageNoteAlignment, tb.lastAction, nn.Descr, shape, maxWidth, nn.Span}, nil
+	}
+
+	// Notes across the diagram are not placed against any actor
+	if nn.Actor1 == nil {
+		return &Note{nil, nil, noteAlignmentMap[nn.Position], nil, nn.Descr, shape, maxWidth, nn.Span}, nil
+	}
+
 	actor1, err := tb.getOrAddActor(nn.Actor1, d)
 	if err != nil {
 		return nil, err
@@ -191,14 +677,64 @@ func (tb *treeBuilder) addNote(nn *parse.NoteNode, d *Diagram) (SequenceItem, er
 		}
 	}
 
-	note := &Note{actor1, actor2, noteAlignmentMap[nn.Position], nn.Descr}
+	if actor1.isEndpoint() || ((actor2 != nil) && actor2.isEndpoint()) {
+		return nil, tb.makeError("Notes cannot be placed over found or lost message endpoints")
+	} else if err := tb.checkCreated(actor1, actor2); err != nil {
+		return nil, err
+	}
+
+	note := &Note{actor1, actor2, noteAlignmentMap[nn.Position], nil, nn.Descr, shape, maxWidth, nn.Span}
 	return note, nil
 }
 
+func (tb *treeBuilder) addRef(rn *parse.RefNode, d *Diagram) (SequenceItem, error) {
+	actor1, err := tb.getOrAddActor(rn.Actor1, d)
+	if err != nil {
+		return nil, err
+	}
+
+	actor2 := actor1
+	if rn.Actor2 != nil {
+		actor2, err = tb.getOrAddActor(rn.Actor2, d)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if actor1.isEndpoint() || actor2.isEndpoint() {
+		return nil, tb.makeError("References cannot be placed over found or lost message endpoints")
+	} else if err := tb.checkCreated(actor1, actor2); err != nil {
+		return nil, err
+	}
+
+	return &Ref{actor1, actor2, rn.Descr}, nil
+}
+
 func (tb *treeBuilder) getOrAddActor(ar parse.ActorRef, d *Diagram) (*Actor, error) {
+	actor, err := tb.lookupActor(ar, d)
+	if (err == nil) && !actor.Span.IsValid() && (actor.rank >= 0) {
+		actor.Span = tb.span
+	}
+	return actor, err
+}
+
+// Returns the actor an actor reference refers to, adding it if it does not exist
+func (tb *treeBuilder) lookupActor(ar parse.ActorRef, d *Diagram) (*Actor, error) {
 	switch a := ar.(type) {
 	case parse.NormalActorRef:
 		return d.GetOrAddActor(string(a)), nil
+	case parse.QuotedActorRef:
+		for _, actor := range d.Actors {
+			if actor.Name == string(a) {
+				return actor, nil
+			}
+		}
+		for _, actor := range d.Actors {
+			if actor.Label == string(a) {
+				return actor, nil
+			}
+		}
+		return d.GetOrAddActor(string(a)), nil
 	case parse.PseudoActorRef:
 		pn := string(a)
 		switch pn {
@@ -206,16 +742,20 @@ func (tb *treeBuilder) getOrAddActor(ar parse.ActorRef, d *Diagram) (*Actor, err
 			return LeftOffsideActor, nil
 		case "right":
 			return RightOffsideActor, nil
+		case "found":
+			return FoundActor, nil
+		case "lost":
+			return LostActor, nil
 		default:
-			return nil, fmt.Errorf("Invalid pseudo actor: %s", pn)
+			return nil, tb.makeError("Invalid pseudo actor: " + pn)
 		}
 	default:
-		return nil, fmt.Errorf("Unknown actor reference: %#v", a)
+		return nil, tb.makeError(fmt.Sprintf("Unknown actor reference: %#v", a))
 	}
 }
 
 func (tb *treeBuilder) addGap(gn *parse.GapNode, d *Diagram) (SequenceItem, error) {
-	divider := &Divider{gn.Descr, dividerTypeMap[gn.Type]}
+	divider := &Divider{gn.Descr, dividerTypeMap[gn.Type], gn.Span}
 	return divider, nil
 }
 
@@ -230,19 +770,27 @@ func (tb *treeBuilder) addBlock(bn *parse.BlockNode, d *Diagram) (SequenceItem,
 		segs = append(segs, seg)
 	}
 
-	return &Block{segs}, nil
+	return &Block{segs, bn.Span}, nil
 }
 
 func (tb *treeBuilder) buildSegment(sn *parse.BlockSegment, d *Diagram) (*BlockSegment, error) {
-	attrs, err := tb.attrsToMap(sn.AttributeList, tb.styleDefs[styleIdentifierParticipant])
+	attrs, err := tb.attrsToMap(sn.AttributeList, tb.styleDefs[styleIdentifierBlock])
 	if err != nil {
 		return nil, err
 	}
 
+	// Notes on messages cannot refer to a message outside the segment
+	tb.lastAction = nil
 	slice, err := tb.nodesToSlice(sn.SubNodes, d)
 	if err != nil {
 		return nil, err
 	}
+	tb.lastAction = nil
+
+	maxWidth, err := tb.getMaxWidth(attrs)
+	if err != nil {
+		return nil, err
+	}
 
 	return &BlockSegment{
 		Type:      segmentTypeMap[sn.Type],
@@ -250,9 +798,31 @@ func (tb *treeBuilder) buildSegment(sn *parse.BlockSegment, d *Diagram) (*BlockS
 		Message:   sn.Message,
 		FullWidth: attrs.GetBool("fullwidth", false),
 		SubItems:  slice,
+
+		MessageNames: sn.MessageNames,
+		MaxTextWidth: maxWidth,
+		Span:         sn.Span,
 	}, nil
 }
 
+// Returns the value of the "maxwidth" attribute, or zero if it is not set
+func (tb *treeBuilder) getMaxWidth(attrs *AttributeSet) (int, error) {
+	maxWidth, err := attrs.GetInt("maxwidth", 0)
+	if err != nil || maxWidth < 0 {
+		return 0, tb.makeError("Invalid maxwidth: " + attrs.GetDef("maxwidth", "") + ": must be a positive number")
+	}
+	return maxWidth, nil
+}
+
+// Returns the "maxwidth" attribute of the message style, or zero if it is not set
+func (tb *treeBuilder) getMessageMaxWidth() (int, error) {
+	attrs, err := tb.attrsToMap(nil, tb.styleDefs[styleIdentifierMessage])
+	if err != nil {
+		return 0, err
+	}
+	return tb.getMaxWidth(attrs)
+}
+
 func (tb *treeBuilder) attrsToMap(attrs *parse.AttributeList, parent *AttributeSet) (*AttributeSet, error) {
 	attrMaps := make(map[string]string)
 
@@ -290,6 +860,27 @@ func (as *AttributeSet) GetDef(name string, def string) string {
 	}
 }
 
+// Returns all the attributes defined in the set or its parents
+func (as *AttributeSet) All() map[string]string {
+	attrs := make(map[string]string)
+	if as.Parent != nil {
+		attrs = as.Parent.All()
+	}
+	for name, value := range as.Attrs {
+		attrs[name] = value
+	}
+	return attrs
+}
+
+// Gets an integer value.  If the value is undefined, returns the default.
+func (as *AttributeSet) GetInt(name string, def int) (int, error) {
+	if value, hasValue := as.Get(name); hasValue {
+		return strconv.Atoi(strings.TrimSpace(value))
+	} else {
+		return def, nil
+	}
+}
+
 // Gets a boolean value.  If the value is undefined, returns the default.
 func (as *AttributeSet) GetBool(name string, def bool) bool {
 	if value, hasValue := as.Get(name); hasValue {
@@ -7,3 +7,11 @@ func maxInt(x int, y int) int {
 		return y
 	}
 }
+
+func minInt(x int, y int) int {
+	if x < y {
+		return x
+	} else {
+		return y
+	}
+}